@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const defaultCupfile = "Cupfile"
+
+// CupfileEntry is one line of a Cupfile: a package name and an optional
+// version constraint, e.g. "ripgrep" or "libfoo >=1.2 <2".
+type CupfileEntry struct {
+	Name       string
+	Constraint string
+}
+
+func (e CupfileEntry) spec() string {
+	if e.Constraint == "" {
+		return e.Name
+	}
+	return e.Name + "@" + e.Constraint
+}
+
+func parseCupfile(path string) ([]CupfileEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []CupfileEntry
+	seen := make(map[string]int)
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// The name ends at the first space or tab
+		name, constraint := line, ""
+		if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+			name, constraint = line[:i], line[i:]
+		}
+		if n, c := parsePackageSpec(name); c != "" {
+			name, constraint = n, c+" "+constraint
+		}
+		constraint = strings.TrimSpace(constraint)
+
+		if constraint != "" {
+			if _, err := ParseConstraint(constraint); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+			}
+		}
+		if prev, ok := seen[name]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is already listed on line %d", path, lineNum, name, prev)
+		}
+		seen[name] = lineNum
+
+		entries = append(entries, CupfileEntry{Name: name, Constraint: constraint})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+
+	return entries, nil
+}
+
+func bundle(args []string) {
+	subcommand := "install"
+	cupfilePath := defaultCupfile
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--file" && i+1 < len(args):
+			cupfilePath = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--file="):
+			cupfilePath = strings.TrimPrefix(arg, "--file=")
+		case !strings.HasPrefix(arg, "-"):
+			subcommand = arg
+		}
+	}
+
+	entries, err := parseCupfile(cupfilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Printf("Error: no %s found\n", cupfilePath)
+		} else {
+			fmt.Printf("Error: %v\n", err)
+		}
+		// check is used in scripts and CI, so it must not pass by default
+		if subcommand == "check" {
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch subcommand {
+	case "install":
+		bundleInstall(entries)
+	case "check":
+		if !bundleCheck(entries) {
+			os.Exit(1)
+		}
+	case "cleanup":
+		bundleCleanup(entries)
+	default:
+		fmt.Printf("Unknown bundle command: %s\n", subcommand)
+		fmt.Println("Usage: cupertino bundle [install|check|cleanup] [--file Cupfile]")
+	}
+}
+
+// bundleStatus reports why an entry needs installing, or "" if the installed
+// version already satisfies it.
+func bundleStatus(db *SQLitePackageDB, entry CupfileEntry) (string, error) {
+	if !db.HasAnyVersion(entry.Name) {
+		return "not installed", nil
+	}
+
+	installedVersion, err := db.GetInstalledVersion(entry.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if entry.Constraint == "" {
+		return "", nil
+	}
+
+	constraint, err := ParseConstraint(entry.Constraint)
+	if err != nil {
+		return "", err
+	}
+
+	version, err := ParseVersion(installedVersion)
+	if err != nil || !constraint.Satisfies(version) {
+		return fmt.Sprintf("installed %s does not satisfy %s", installedVersion, entry.Constraint), nil
+	}
+
+	return "", nil
+}
+
+func bundleInstall(entries []CupfileEntry) {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+
+	var missing []CupfileEntry
+	for _, entry := range entries {
+		status, err := bundleStatus(db, entry)
+		if err != nil {
+			fmt.Printf("Error checking %s: %v\n", entry.Name, err)
+			db.Close()
+			return
+		}
+		if status == "" {
+			installedVersion, _ := db.GetInstalledVersion(entry.Name)
+			fmt.Printf("Using %s v%s\n", entry.Name, installedVersion)
+			continue
+		}
+		missing = append(missing, entry)
+	}
+	db.Close()
+
+	if len(missing) == 0 {
+		fmt.Println("The Cupfile's dependencies are satisfied")
+		return
+	}
+
+	failed := 0
+	for _, entry := range missing {
+		fmt.Printf("\nInstalling %s...\n", entry.spec())
+		if err := installFromRegistry(entry.spec(), installOptions{Explicit: true}); err != nil {
+			fmt.Printf("Error installing %s: %v\n", entry.Name, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d of %d package(s) failed to install\n", failed, len(missing))
+		return
+	}
+
+	fmt.Printf("\n✅ Bundle complete: %d package(s) installed\n", len(missing))
+}
+
+// bundleCheck prints every Cupfile entry that is missing or out of range and
+// reports whether the installation matches the Cupfile.
+func bundleCheck(entries []CupfileEntry) bool {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return false
+	}
+	defer db.Close()
+
+	satisfied := true
+	for _, entry := range entries {
+		status, err := bundleStatus(db, entry)
+		if err != nil {
+			fmt.Printf("Error checking %s: %v\n", entry.Name, err)
+			return false
+		}
+		if status != "" {
+			fmt.Printf("  %-20s %s\n", entry.Name, status)
+			satisfied = false
+		}
+	}
+
+	if satisfied {
+		fmt.Println("The Cupfile's dependencies are satisfied")
+	} else {
+		fmt.Println("Run 'cupertino bundle install' to install missing packages")
+	}
+	return satisfied
+}
+
+// bundleCleanup removes installed packages that are neither listed in the
+// Cupfile nor needed by a listed package.
+func bundleCleanup(entries []CupfileEntry) {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	keep := make(map[string]bool)
+	var walk func(name string) error
+	walk = func(name string) error {
+		if keep[name] {
+			return nil
+		}
+		keep[name] = true
+
+		deps, err := db.GetDependencies(name)
+		if err != nil {
+			return err
+		}
+		for _, dep := range deps {
+			if err := walk(dep.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, entry := range entries {
+		if err := walk(entry.Name); err != nil {
+			fmt.Printf("Error reading dependencies of %s: %v\n", entry.Name, err)
+			return
+		}
+	}
+
+	installed, err := db.List()
+	if err != nil {
+		fmt.Printf("Error listing packages: %v\n", err)
+		return
+	}
+
+	var extra []*InstalledPackage
+	for _, pkg := range installed {
+		if !keep[pkg.Name] {
+			extra = append(extra, pkg)
+		}
+	}
+
+	if len(extra) == 0 {
+		fmt.Println("Nothing to clean up")
+		return
+	}
+
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Name < extra[j].Name })
+
+	fmt.Printf("%d package(s) are not in the Cupfile:\n", len(extra))
+	for _, pkg := range extra {
+		fmt.Printf("  %-20s %s\n", pkg.Name, pkg.Version)
+	}
+
+	if !confirmAction("Uninstall them?") {
+		fmt.Println("Cleanup cancelled.")
+		return
+	}
+
+	removed := 0
+	for _, pkg := range extra {
+		versions, err := db.ListVersions(pkg.Name)
+		if err != nil {
+			fmt.Printf("Error removing %s: %v\n", pkg.Name, err)
+			continue
+		}
+
+		ok := true
+		for _, version := range versions {
+			if _, err := removeInstalledPackage(db, version); err != nil {
+				fmt.Printf("Error removing %s: %v\n", pkg.Name, err)
+				ok = false
+				break
+			}
+		}
+		if ok {
+			removed++
+		}
+	}
+
+	fmt.Printf("✅ Removed %d package(s)\n", removed)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The download cache keeps verified tarballs under <cache_dir>/<sha256>.tar.gz.
+// Each tarball has a <sha256>.json file next to it holding the registry
+// metadata, so --offline installs can be resolved from the cache alone.
+// Downloads go to <sha256>.tar.gz.partial while holding <sha256>.tar.gz.lock,
+// so concurrent downloads of one tarball take turns.
+
+type cacheEntry struct {
+	Package  RegistryPackage `json:"package"`
+	Registry string          `json:"registry"`
+	CachedAt time.Time       `json:"cached_at"`
+
+	checksum string
+	size     int64
+	lastUsed time.Time
+}
+
+func getCacheDir() string {
+	return absPath(configValue("cache_dir"))
+}
+
+func isOffline() bool {
+	return configBool("offline")
+}
+
+// validChecksum reports whether checksum is a lowercase hex SHA-256. Only
+// valid checksums may be used in cache paths, since they come from the
+// registry.
+func validChecksum(checksum string) bool {
+	if len(checksum) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range checksum {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func cachedTarballPath(checksum string) string {
+	return filepath.Join(getCacheDir(), checksum+".tar.gz")
+}
+
+func cacheMetadataPath(checksum string) string {
+	return filepath.Join(getCacheDir(), checksum+".json")
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// cachedTarball returns the cached tarball for checksum if it is present and
+// intact. A corrupt entry is removed.
+func cachedTarball(checksum string) (string, bool) {
+	if !validChecksum(checksum) {
+		return "", false
+	}
+
+	path := cachedTarballPath(checksum)
+	actual, err := fileChecksum(path)
+	if err != nil {
+		return "", false
+	}
+	if actual != checksum {
+		os.Remove(path)
+		return "", false
+	}
+
+	// The modification time records the last use, for `cache prune`
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return path, true
+}
+
+// addToCache moves a verified download into the cache and records its
+// registry metadata.
+func addToCache(downloadPath string, regPkg *RegistryPackage) (string, error) {
+	path := cachedTarballPath(regPkg.Checksum)
+	if err := os.Rename(downloadPath, path); err != nil {
+		os.Remove(downloadPath)
+		return "", err
+	}
+
+	if err := writeCacheMetadata(regPkg); err != nil {
+		fmt.Printf("Warning: could not record cache metadata: %v\n", err)
+	}
+	return path, nil
+}
+
+func writeCacheMetadata(regPkg *RegistryPackage) error {
+	entry := cacheEntry{Package: *regPkg, Registry: regPkg.Registry, CachedAt: time.Now()}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cacheMetadataPath(regPkg.Checksum), data, 0644)
+}
+
+// listCacheEntries returns every cached tarball, with metadata where known.
+func listCacheEntries() ([]*cacheEntry, error) {
+	files, err := os.ReadDir(getCacheDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*cacheEntry
+	for _, file := range files {
+		checksum, ok := strings.CutSuffix(file.Name(), ".tar.gz")
+		if !ok || file.IsDir() {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		entry := &cacheEntry{}
+		if data, err := os.ReadFile(cacheMetadataPath(checksum)); err == nil {
+			json.Unmarshal(data, entry)
+		}
+		entry.checksum = checksum
+		entry.size = info.Size()
+		entry.lastUsed = info.ModTime()
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (entry *cacheEntry) remove() error {
+	os.Remove(cacheMetadataPath(entry.checksum))
+	return os.Remove(cachedTarballPath(entry.checksum))
+}
+
+// cachedPackageInfo answers a package info request from the cache in
+// --offline mode.
+func cachedPackageInfo(name string) (*RegistryPackageInfo, error) {
+	entries, err := listCacheEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &RegistryPackageInfo{Name: name}
+	for _, entry := range entries {
+		if entry.Package.Name != name {
+			continue
+		}
+		info.Versions = append(info.Versions, entry.Package.Version)
+		info.Description = entry.Package.Description
+		info.Homepage = entry.Package.Homepage
+		info.License = entry.Package.License
+		info.Registry = entry.Registry
+	}
+
+	if len(info.Versions) == 0 {
+		return nil, fmt.Errorf("package '%s' is not in the download cache (offline)", name)
+	}
+
+	info.Versions = sortVersionsDesc(info.Versions)
+	info.Latest = info.Versions[0]
+	return info, nil
+}
+
+// cachedPackage answers a package version request from the cache in
+// --offline mode.
+func cachedPackage(name, version string) (*RegistryPackage, error) {
+	entries, err := listCacheEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Package.Name == name && entry.Package.Version == version {
+			pkg := entry.Package
+			pkg.Registry = entry.Registry
+			return &pkg, nil
+		}
+	}
+
+	return nil, fmt.Errorf("package '%s' version '%s' is not in the download cache (offline)", name, version)
+}
+
+// searchCache is search for --offline mode: it matches cached package names
+// and descriptions.
+func searchCache(query string) {
+	entries, err := listCacheEntries()
+	if err != nil {
+		printError("reading cache: %v", err)
+		return
+	}
+
+	query = strings.ToLower(query)
+	latest := make(map[string]*RegistryPackage)
+	for _, entry := range entries {
+		pkg := &entry.Package
+		if pkg.Name == "" || !(strings.Contains(strings.ToLower(pkg.Name), query) ||
+			strings.Contains(strings.ToLower(pkg.Description), query)) {
+			continue
+		}
+		current, ok := latest[pkg.Name]
+		if !ok || sortVersionsDesc([]string{pkg.Version, current.Version})[0] == pkg.Version {
+			latest[pkg.Name] = pkg
+		}
+	}
+
+	names := make([]string, 0, len(latest))
+	for name := range latest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if outputJSON() {
+		results := make([]RegistryPackageInfo, len(names))
+		for i, name := range names {
+			pkg := latest[name]
+			results[i] = RegistryPackageInfo{
+				Name:        pkg.Name,
+				Description: pkg.Description,
+				Homepage:    pkg.Homepage,
+				License:     pkg.License,
+				Versions:    []string{pkg.Version},
+				Latest:      pkg.Version,
+				Registry:    pkg.Registry,
+			}
+		}
+		printJSON(results)
+		return
+	}
+
+	if len(latest) == 0 {
+		fmt.Printf("No cached packages found for '%s'\n", query)
+		return
+	}
+
+	for _, name := range names {
+		fmt.Printf("  %-20s %-10s %s\n", name, latest[name].Version, latest[name].Description)
+	}
+}
+
+// parseAge parses a duration that may also be given in days, e.g. "30d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q (use e.g. 30d or 12h)", s)
+	}
+	return d, nil
+}
+
+func cacheCommand(args []string) {
+	subcommand := "list"
+	olderThan := "30d"
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--older-than" && i+1 < len(args):
+			olderThan = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--older-than="):
+			olderThan = strings.TrimPrefix(arg, "--older-than=")
+		case !strings.HasPrefix(arg, "-"):
+			subcommand = arg
+		}
+	}
+
+	switch subcommand {
+	case "list":
+		cacheList()
+	case "clean":
+		cacheClean()
+	case "prune":
+		age, err := parseAge(olderThan)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		cachePrune(age)
+	default:
+		fmt.Printf("Unknown cache command: %s\n", subcommand)
+		fmt.Println("Usage: cupertino cache [list|clean|prune --older-than 30d]")
+	}
+}
+
+func cacheList() {
+	entries, err := listCacheEntries()
+	if err != nil {
+		fmt.Printf("Error reading cache: %v\n", err)
+		return
+	}
+
+	if len(entries) == 0 {
+		fmt.Printf("The download cache is empty (%s)\n", getCacheDir())
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Package.Name != entries[j].Package.Name {
+			return entries[i].Package.Name < entries[j].Package.Name
+		}
+		return entries[i].Package.Version < entries[j].Package.Version
+	})
+
+	var total int64
+	fmt.Printf("Cached downloads in %s:\n", getCacheDir())
+	for _, entry := range entries {
+		name, version := entry.Package.Name, entry.Package.Version
+		if name == "" {
+			name, version = "(unknown)", entry.checksum[:min(12, len(entry.checksum))]
+		}
+		fmt.Printf("  %-20s %-10s %10s  (last used %s)\n",
+			name, version, formatBytes(entry.size), entry.lastUsed.Format("2006-01-02"))
+		total += entry.size
+	}
+	fmt.Printf("%d tarball(s), %s\n", len(entries), formatBytes(total))
+}
+
+func cacheClean() {
+	entries, err := listCacheEntries()
+	if err != nil {
+		fmt.Printf("Error reading cache: %v\n", err)
+		return
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("The download cache is empty")
+		return
+	}
+
+	if !confirmAction(fmt.Sprintf("Remove all %d cached tarball(s)?", len(entries))) {
+		fmt.Println("Clean cancelled.")
+		return
+	}
+
+	removeCacheEntries(entries)
+
+	// Also drop partly downloaded files that were never resumed
+	partials, _ := filepath.Glob(filepath.Join(getCacheDir(), "*.partial"))
+	for _, partial := range partials {
+		// Wait for a download still writing to it
+		unlock, err := lockFile(strings.TrimSuffix(partial, ".partial")+".lock", "Waiting for a download to finish...")
+		if err != nil {
+			continue
+		}
+		os.Remove(partial)
+		unlock()
+	}
+}
+
+// cachePrune removes tarballs that have not been used within age.
+func cachePrune(age time.Duration) {
+	entries, err := listCacheEntries()
+	if err != nil {
+		fmt.Printf("Error reading cache: %v\n", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-age)
+	var stale []*cacheEntry
+	for _, entry := range entries {
+		if entry.lastUsed.Before(cutoff) {
+			stale = append(stale, entry)
+		}
+	}
+
+	if len(stale) == 0 {
+		fmt.Println("Nothing to prune")
+		return
+	}
+
+	removeCacheEntries(stale)
+}
+
+func removeCacheEntries(entries []*cacheEntry) {
+	removed, freed := 0, int64(0)
+	for _, entry := range entries {
+		if err := entry.remove(); err != nil {
+			fmt.Printf("Warning: could not remove %s: %v\n", entry.checksum, err)
+			continue
+		}
+		removed++
+		freed += entry.size
+	}
+
+	fmt.Printf("✅ Removed %d tarball(s), freed %s\n", removed, formatBytes(freed))
+}
@@ -5,20 +5,19 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
 var yesFlag = flag.Bool("y", false, "Assume yes to all prompts")
-
-func init() {
-	flag.Parse()
-}
+var prefixFlag = flag.String("prefix", "", "Install prefix (default $CUPERTINO_HOME, /opt/cupertino or ~/.cupertino)")
+var registryFlag = flag.String("registry", "", "Registry URL (default $CUPERTINO_REGISTRY or https://cupertino.sh)")
+var offlineFlag = flag.Bool("offline", false, "Install only from the download cache")
 
 func confirmAction(message string) bool {
-	if *yesFlag {
+	if configBool("assume_yes") {
 		return true
 	}
 
@@ -32,7 +31,7 @@ func confirmAction(message string) bool {
 }
 
 func uninstall(args []string) {
-	packageName := args[0]
+	packageName, version := parsePackageSpec(args[0])
 
 	db, err := NewSQLitePackageDB(getDatabasePath())
 	if err != nil {
@@ -46,14 +45,36 @@ func uninstall(args []string) {
 		return
 	}
 
+	// Without a version, every installed version is removed
+	var targets []*InstalledPackage
+	if version != "" {
+		pkg, err := db.GetVersion(packageName, version)
+		if err != nil {
+			fmt.Printf("%s v%s is not installed\n", packageName, version)
+			return
+		}
+		targets = append(targets, pkg)
+	} else {
+		targets, err = db.ListVersions(packageName)
+		if err != nil {
+			fmt.Printf("Error getting package info: %v\n", err)
+			return
+		}
+	}
+
+	removingActive := false
+	for _, pkg := range targets {
+		removingActive = removingActive || pkg.Active
+	}
+
 	dependents, err := db.GetDependents(packageName)
 	if err != nil {
 		fmt.Printf("Error checking dependencies: %v\n", err)
 		return
 	}
 
-	if len(dependents) > 0 {
-		fmt.Printf("Cannot uninstall '%s' - the following packages are dependents:\n", packageName)
+	if removingActive && len(dependents) > 0 {
+		fmt.Printf("Cannot uninstall '%s' - the following packages are dependents:\n", args[0])
 		for _, dep := range dependents {
 			fmt.Printf("  - %s %s\n", dep.Name, dep.Version)
 		}
@@ -62,19 +83,44 @@ func uninstall(args []string) {
 			fmt.Println("Uninstall cancelled.")
 			return
 		}
-	} else if !confirmAction(fmt.Sprintf("Remove %s?", packageName)) {
+	} else if !confirmAction(fmt.Sprintf("Remove %s?", args[0])) {
 		fmt.Println("Uninstall cancelled.")
 		return
 	}
 
-	pkg, err := db.Get(packageName)
-	if err != nil {
-		fmt.Printf("Error getting package info: %v\n", err)
-		return
+	filesRemoved := 0
+	for _, pkg := range targets {
+		n, err := removeInstalledPackage(db, pkg)
+		filesRemoved += n
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
 	}
 
+	// Fall back to the most recently installed remaining version
+	if removingActive && db.HasAnyVersion(packageName) {
+		if next, err := db.Get(packageName); err == nil {
+			if err := switchActiveVersion(db, next.Name, next.Version); err != nil {
+				fmt.Printf("Warning: could not activate %s v%s: %v\n", next.Name, next.Version, err)
+			} else {
+				fmt.Printf("%s now uses v%s\n", next.Name, next.Version)
+			}
+		}
+	}
+
+	fmt.Printf("✅ Successfully uninstalled %s (%d files)\n", args[0], filesRemoved)
+}
+
+// removeInstalledPackage runs the remove hooks and deletes a package's files,
+// symlinks and database rows. It returns the number of files removed.
+func removeInstalledPackage(db *SQLitePackageDB, pkg *InstalledPackage) (int, error) {
 	fmt.Printf("Uninstalling %s v%s...\n", pkg.Name, pkg.Version)
 
+	if err := runLifecycleScripts(&pkg.Package, hookPreRemove, pkg.InstallPath); err != nil {
+		return 0, fmt.Errorf("uninstall aborted: %v", err)
+	}
+
 	dirsToCleanup := make(map[string]bool)
 	filesRemoved := 0
 
@@ -97,25 +143,54 @@ func uninstall(args []string) {
 
 	removeSymlinks(pkg)
 
-	if err := db.Remove(packageName); err != nil {
-		fmt.Printf("Error deleting from database: %v\n", err)
-		return
+	if err := db.RemoveVersion(pkg.Name, pkg.Version); err != nil {
+		return filesRemoved, fmt.Errorf("deleting from database: %v", err)
 	}
 
-	fmt.Printf("✅ Successfully uninstalled %s (%d files)\n", packageName, filesRemoved)
+	if err := runLifecycleScripts(&pkg.Package, hookPostRemove, pkg.InstallPath); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	return filesRemoved, nil
+}
+
+// listedPackage is an entry of `list --json`: one per installed version.
+type listedPackage struct {
+	*InstalledPackage
+	Pin string `json:"pin,omitempty"`
 }
 
 func list() {
 	db, err := NewSQLitePackageDB(getDatabasePath())
 	if err != nil {
-		fmt.Printf("Error opening database: %v\n", err)
+		printError("opening database: %v", err)
 		return
 	}
 	defer db.Close()
 
 	packages, err := db.List()
 	if err != nil {
-		fmt.Printf("Error listing packages: %v\n", err)
+		printError("listing packages: %v", err)
+		return
+	}
+
+	pins, err := db.Pins()
+	if err != nil {
+		printError("reading pins: %v", err)
+		return
+	}
+
+	if outputJSON() {
+		versions, err := db.ListAll()
+		if err != nil {
+			printError("listing packages: %v", err)
+			return
+		}
+		listed := make([]listedPackage, len(versions))
+		for i, pkg := range versions {
+			listed[i] = listedPackage{InstalledPackage: pkg, Pin: pins[pkg.Name]}
+		}
+		printJSON(listed)
 		return
 	}
 
@@ -128,34 +203,82 @@ func list() {
 	for _, pkg := range packages {
 		installDate := pkg.InstallDate.Format("2006-01-02")
 
-		fmt.Printf("  %-20s %-10s (installed %s)\n",
-			pkg.Name, pkg.Version, installDate)
+		pinned := ""
+		if pin, ok := pins[pkg.Name]; ok {
+			pinned = fmt.Sprintf(" 📌 pinned to %s", pin)
+		}
+
+		fmt.Printf("  %-20s %-10s (installed %s)%s\n",
+			pkg.Name, pkg.Version, installDate, pinned)
 
 		if pkg.Description != "" {
 			fmt.Printf("    %s\n", pkg.Description)
 		}
+
+		if others := otherInstalledVersions(db, pkg); len(others) > 0 {
+			fmt.Printf("    also installed: %s\n", strings.Join(others, ", "))
+		}
 	}
 }
 
-func search(query string) {
-	registryURL := getRegistryURL()
-	url := fmt.Sprintf("%s/api/search?q=%s&limit=20", registryURL, query)
-
-	resp, err := http.Get(url)
+// otherInstalledVersions returns the installed versions of pkg other than
+// pkg.Version, marking the one kept for rollback.
+func otherInstalledVersions(db *SQLitePackageDB, pkg *InstalledPackage) []string {
+	versions, err := db.ListVersions(pkg.Name)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		fmt.Printf("Error: registry returned HTTP %d\n", resp.StatusCode)
+	var others []string
+	for _, version := range versions {
+		if version.Version == pkg.Version {
+			continue
+		}
+		if version.Retained {
+			others = append(others, version.Version+" (previous)")
+		} else {
+			others = append(others, version.Version)
+		}
+	}
+	return others
+}
+
+func search(query string) {
+	if isOffline() {
+		searchCache(query)
 		return
 	}
 
+	registries := getRegistries()
+
 	var results []RegistryPackageInfo
-	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
-		fmt.Printf("Error parsing results: %v\n", err)
+	seen := make(map[string]bool)
+	for _, registry := range registries {
+		found, err := searchRegistry(registry.URL, query)
+		if err != nil {
+			if len(registries) == 1 {
+				printError("%v", err)
+				return
+			}
+			fmt.Printf("Warning: %s registry: %v\n", registry.Name, err)
+			continue
+		}
+
+		// Earlier registries shadow later ones, as they do for installs
+		for _, pkg := range found {
+			if !seen[pkg.Name] {
+				seen[pkg.Name] = true
+				pkg.Registry = registry.URL
+				results = append(results, pkg)
+			}
+		}
+	}
+
+	if outputJSON() {
+		if results == nil {
+			results = []RegistryPackageInfo{}
+		}
+		printJSON(results)
 		return
 	}
 
@@ -165,15 +288,68 @@ func search(query string) {
 	}
 
 	for _, pkg := range results {
-		fmt.Printf("  %-20s %-10s %s\n", pkg.Name, pkg.Latest, pkg.Description)
+		if len(registries) > 1 {
+			fmt.Printf("  %-20s %-10s [%s] %s\n", pkg.Name, pkg.Latest, registryName(pkg.Registry), pkg.Description)
+		} else {
+			fmt.Printf("  %-20s %-10s %s\n", pkg.Name, pkg.Latest, pkg.Description)
+		}
+	}
+}
+
+func searchRegistry(registryURL, query string) ([]RegistryPackageInfo, error) {
+	searchURL := fmt.Sprintf("%s/api/search?q=%s&limit=20", registryURL, url.QueryEscape(query))
+
+	resp, err := httpGet(searchURL)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("registry returned HTTP %d", resp.StatusCode)
+	}
+
+	var results []RegistryPackageInfo
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("parsing results: %v", err)
+	}
+
+	return results, nil
+}
+
+// packageDetails is the output of `info --json`.
+type packageDetails struct {
+	*RegistryPackageInfo
+	Installed         string   `json:"installed,omitempty"` // active installed version
+	InstalledVersions []string `json:"installed_versions,omitempty"`
+	Pin               string   `json:"pin,omitempty"`
 }
 
 func info(packageName string) {
-	registryURL := getRegistryURL()
-	pkgInfo, err := getPackageInfo(registryURL, packageName)
+	pkgInfo, err := lookupPackage(packageName)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+		printError("%v", err)
+		return
+	}
+
+	// Check if installed locally
+	details := packageDetails{RegistryPackageInfo: pkgInfo}
+	var others []string
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err == nil {
+		defer db.Close()
+		if pkg, err := db.Get(packageName); err == nil {
+			details.Installed = pkg.Version
+			details.InstalledVersions, _ = db.GetInstalledVersions(packageName)
+			others = otherInstalledVersions(db, pkg)
+			if pin, err := db.GetPin(packageName); err == nil {
+				details.Pin = pin
+			}
+		}
+	}
+
+	if outputJSON() {
+		printJSON(details)
 		return
 	}
 
@@ -191,23 +367,26 @@ func info(packageName string) {
 	if pkgInfo.Homepage != "" {
 		fmt.Printf("  homepage:  %s\n", pkgInfo.Homepage)
 	}
+	if name := registryName(pkgInfo.Registry); name != pkgInfo.Registry {
+		fmt.Printf("  registry:  %s (%s)\n", name, pkgInfo.Registry)
+	} else {
+		fmt.Printf("  registry:  %s\n", pkgInfo.Registry)
+	}
 
 	fmt.Printf("\n  versions:  %s\n", strings.Join(pkgInfo.Versions, ", "))
 
-	// Check if installed locally
-	db, err := NewSQLitePackageDB(getDatabasePath())
-	if err == nil {
-		defer db.Close()
-		if db.HasAnyVersion(packageName) {
-			installedVersion, _ := db.GetInstalledVersion(packageName)
-			fmt.Printf("\n  installed: %s\n", installedVersion)
+	if details.Installed != "" {
+		fmt.Printf("\n  installed: %s\n", details.Installed)
+		if len(others) > 0 {
+			fmt.Printf("  also:      %s\n", strings.Join(others, ", "))
+		}
+		if details.Pin != "" {
+			fmt.Printf("  pinned:    %s\n", details.Pin)
 		}
 	}
 }
 
 func upgrade(packageName string) {
-	registryURL := getRegistryURL()
-
 	db, err := NewSQLitePackageDB(getDatabasePath())
 	if err != nil {
 		fmt.Printf("Error opening database: %v\n", err)
@@ -226,25 +405,41 @@ func upgrade(packageName string) {
 		return
 	}
 
-	pkgInfo, err := getPackageInfo(registryURL, packageName)
+	pkgInfo, err := lookupPackage(packageName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	pin, err := db.GetPin(packageName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	target, err := upgradeTarget(pkgInfo, pin)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
 
-	if pkgInfo.Latest == installedVersion {
-		fmt.Printf("%s is already up to date (v%s)\n", packageName, installedVersion)
+	if target == installedVersion {
+		if target != pkgInfo.Latest {
+			fmt.Printf("%s is pinned to %s (v%s, latest is v%s)\n", packageName, pin, installedVersion, pkgInfo.Latest)
+		} else {
+			fmt.Printf("%s is already up to date (v%s)\n", packageName, installedVersion)
+		}
 		return
 	}
 
-	fmt.Printf("%s: %s -> %s\n", packageName, installedVersion, pkgInfo.Latest)
+	fmt.Printf("%s: %s -> %s\n", packageName, installedVersion, target)
 
 	if !confirmAction("Upgrade?") {
 		fmt.Println("Upgrade cancelled.")
 		return
 	}
 
-	if err := installFromRegistry(packageName + "@" + pkgInfo.Latest); err != nil {
+	if err := installFromRegistry(packageName+"@"+target, installOptions{}); err != nil {
 		fmt.Printf("Error: %v\n", err)
 	}
 }
@@ -268,19 +463,57 @@ func upgradeAll() {
 		return
 	}
 
-	registryURL := getRegistryURL()
+	pins, err := db.Pins()
+	if err != nil {
+		fmt.Printf("Error reading pins: %v\n", err)
+		return
+	}
+
 	var upgradeable []struct{ name, from, to string }
+	var held []string
 
-	for _, pkg := range packages {
-		pkgInfo, err := getPackageInfo(registryURL, pkg.Name)
+	fmt.Printf("Checking %d package(s) for updates...\n", len(packages))
+
+	infos := make([]*RegistryPackageInfo, len(packages))
+	err = runParallel(len(packages), func(i int) error {
+		info, err := lookupPackage(packages[i].Name)
+		if err != nil {
+			return fmt.Errorf("  %s: %v", packages[i].Name, err)
+		}
+		infos[i] = info
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Warning: could not check some packages:\n%v\n", err)
+	}
+
+	for i, pkg := range packages {
+		pkgInfo := infos[i]
+		if pkgInfo == nil {
+			continue
+		}
+
+		target, err := upgradeTarget(pkgInfo, pins[pkg.Name])
 		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
 			continue
 		}
-		if pkgInfo.Latest != pkg.Version {
+
+		if target != pkg.Version {
 			upgradeable = append(upgradeable, struct{ name, from, to string }{
-				pkg.Name, pkg.Version, pkgInfo.Latest,
+				pkg.Name, pkg.Version, target,
 			})
 		}
+		if target != pkgInfo.Latest {
+			held = append(held, fmt.Sprintf("  %-20s pinned to %s (latest is %s)", pkg.Name, pins[pkg.Name], pkgInfo.Latest))
+		}
+	}
+
+	if len(held) > 0 {
+		fmt.Printf("%d pinned package(s) will be held back:\n", len(held))
+		for _, line := range held {
+			fmt.Println(line)
+		}
 	}
 
 	if len(upgradeable) == 0 {
@@ -300,7 +533,7 @@ func upgradeAll() {
 
 	for _, u := range upgradeable {
 		fmt.Printf("\nUpgrading %s...\n", u.name)
-		if err := installFromRegistry(u.name + "@" + u.to); err != nil {
+		if err := installFromRegistry(u.name+"@"+u.to, installOptions{}); err != nil {
 			fmt.Printf("Error upgrading %s: %v\n", u.name, err)
 		}
 	}
@@ -359,16 +592,56 @@ func showUsage() {
 	fmt.Println("")
 	fmt.Println("Usage:")
 	fmt.Println("  cupertino install <package>    Install a package")
+	fmt.Println("    --lock                       Record the resolved versions in cupertino.lock")
+	fmt.Println("    --frozen                     Install exactly what cupertino.lock records")
+	fmt.Println("    --keep                       Install alongside other versions instead of replacing them")
+	fmt.Println("    --allow-unsigned             Install a local tarball even if signature_policy is enforce")
 	fmt.Println("  cupertino uninstall <package>  Remove a package")
 	fmt.Println("  cupertino search <query>       Search for packages")
 	fmt.Println("  cupertino info <package>       Show package details")
+	fmt.Println("  cupertino deps <pkg>[@ver]     Show what a package depends on (--dot, --installed)")
+	fmt.Println("  cupertino why <package>        Show which installed packages need a package")
 	fmt.Println("  cupertino upgrade [package]    Upgrade packages")
+	fmt.Println("  cupertino outdated [--json]    List packages with newer versions (exit 1 if any)")
+	fmt.Println("  cupertino switch <pkg> <ver>   Switch the active version of a package")
+	fmt.Println("  cupertino rollback <package>   Restore the version before the last upgrade")
+	fmt.Println("  cupertino history [package]    Show install, upgrade and rollback history")
+	fmt.Println("  cupertino pin <pkg>[@range]    Hold a package at its version or within a range")
+	fmt.Println("  cupertino unpin <package>      Allow a pinned package to be upgraded again")
 	fmt.Println("  cupertino list                 List installed packages")
+	fmt.Println("  cupertino verify [package]     Check installed files for changes since install")
+	fmt.Println("  cupertino doctor               Check the installation for problems (--fix to repair)")
+	fmt.Println("  cupertino bundle [install]     Install packages listed in ./Cupfile")
+	fmt.Println("  cupertino bundle check         Report Cupfile packages that are missing")
+	fmt.Println("  cupertino bundle cleanup       Remove packages not listed in ./Cupfile")
 	fmt.Println("  cupertino init                 Create a package.json")
 	fmt.Println("  cupertino publish              Publish a package")
+	fmt.Println("    --sign                       Sign the package with your key from 'cupertino keygen'")
+	fmt.Println("  cupertino keygen               Create a signing key")
+	fmt.Println("  cupertino trust [list]         Show keys trusted to sign packages")
+	fmt.Println("  cupertino trust add <key>      Trust a publisher's public key (trust remove <id>)")
+	fmt.Println("  cupertino login                Save a registry token (--registry <name|url>)")
+	fmt.Println("    --token-stdin                Read the token from stdin instead of prompting")
+	fmt.Println("  cupertino logout               Remove a saved registry token")
+	fmt.Println("  cupertino whoami               Show which registries you are logged in to")
+	fmt.Println("  cupertino config [list]        Show settings and where they come from")
+	fmt.Println("  cupertino config get <key>     Print a setting")
+	fmt.Println("  cupertino config set <k> <v>   Save a setting (--system for all users)")
+	fmt.Println("  cupertino cache [list]         Show cached downloads")
+	fmt.Println("  cupertino cache clean          Empty the download cache")
+	fmt.Println("  cupertino cache prune          Remove downloads unused for 30 days (--older-than)")
 	fmt.Println("  cupertino help                 Show this help")
+	fmt.Println("")
+	fmt.Println("Options:")
+	fmt.Println("  -y                             Assume yes to all prompts")
+	fmt.Println("  --prefix <dir>                 Install prefix (also CUPERTINO_HOME)")
+	fmt.Println("  --registry <url>               Registry URL (also CUPERTINO_REGISTRY)")
+	fmt.Println("  --offline                      Install only from the download cache")
+	fmt.Println("  --json, --format json          Print JSON (see docs/json-output.md)")
 }
 
+const cupertinoVersion = "1.0.0"
+
 func showVersion() {
-	fmt.Println("cupertino v1.0.0")
+	fmt.Printf("cupertino v%s\n", cupertinoVersion)
 }
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const systemConfigPath = "/etc/cupertino/config.json"
+
+// configSetting describes one config key. Values are resolved from, highest
+// precedence first: a command-line flag, an environment variable, the user
+// config file, the system config file, and finally the default.
+type configSetting struct {
+	Key         string
+	Env         string
+	Flag        string
+	Description string
+	Default     func() string
+	Validate    func(value string) error
+	Secret      bool // masked in `config list`
+}
+
+var configSettings []configSetting
+
+// configSettings is filled in by init because some defaults, like cache_dir,
+// are derived from other settings.
+func init() {
+	configSettings = []configSetting{
+		{
+			Key:         "registry",
+			Env:         "CUPERTINO_REGISTRY",
+			Flag:        "registry",
+			Description: "Registry URL",
+			Default:     func() string { return defaultRegistry },
+			Validate:    validateURL,
+		},
+		{
+			Key:         "registries",
+			Env:         "CUPERTINO_REGISTRIES",
+			Description: "Extra registries searched before the default, as name=url,...",
+			Default:     func() string { return "" },
+			Validate:    validateRegistries,
+		},
+		{
+			Key:         "routes",
+			Env:         "CUPERTINO_ROUTES",
+			Description: "Packages that always come from one registry, as pattern=name,...",
+			Default:     func() string { return "" },
+			Validate:    validateRegistryRoutes,
+		},
+		{
+			Key:         "prefix",
+			Env:         "CUPERTINO_HOME",
+			Flag:        "prefix",
+			Description: "Install prefix",
+			Default:     defaultPrefixDir,
+		},
+		{
+			Key:         "cache_dir",
+			Env:         "CUPERTINO_CACHE_DIR",
+			Description: "Download cache directory",
+			Default:     func() string { return filepath.Join(getCupertinoDir(), "cache") },
+		},
+		{
+			Key:         "offline",
+			Env:         "CUPERTINO_OFFLINE",
+			Flag:        "offline",
+			Description: "Install only from the download cache",
+			Default:     func() string { return "false" },
+			Validate:    validateBool,
+		},
+		{
+			Key:         "assume_yes",
+			Env:         "CUPERTINO_YES",
+			Flag:        "y",
+			Description: "Answer yes to all prompts",
+			Default:     func() string { return "false" },
+			Validate:    validateBool,
+		},
+		{
+			Key:         "format",
+			Env:         "CUPERTINO_FORMAT",
+			Flag:        "format",
+			Description: "Output of list, info, search, outdated and install plans: table or json",
+			Default:     func() string { return formatTable },
+			Validate:    validateFormat,
+		},
+		{
+			Key:         "http_timeout",
+			Env:         "CUPERTINO_HTTP_TIMEOUT",
+			Description: "Timeout for each registry request",
+			Default:     func() string { return "60s" },
+			Validate:    validateDuration,
+		},
+		{
+			Key:         "ca_bundle",
+			Env:         "CUPERTINO_CA_BUNDLE",
+			Description: "PEM file of extra CA certificates to trust",
+			Default:     func() string { return "" },
+		},
+		{
+			Key:         "client_certs",
+			Env:         "CUPERTINO_CLIENT_CERTS",
+			Description: "Client certificates per registry, as name=cert.pem:key.pem,...",
+			Default:     func() string { return "" },
+			Validate:    validateClientCerts,
+		},
+		{
+			Key:         "connect_timeout",
+			Env:         "CUPERTINO_CONNECT_TIMEOUT",
+			Description: "Timeout for connecting to a download server",
+			Default:     func() string { return "10s" },
+			Validate:    validateDuration,
+		},
+		{
+			Key:         "read_timeout",
+			Env:         "CUPERTINO_READ_TIMEOUT",
+			Description: "How long a download may stall before it is retried",
+			Default:     func() string { return "30s" },
+			Validate:    validateDuration,
+		},
+		{
+			Key:         "retries",
+			Env:         "CUPERTINO_RETRIES",
+			Description: "Times to retry a failed download",
+			Default:     func() string { return "3" },
+			Validate:    validateNonNegativeInt,
+		},
+		{
+			Key:         "jobs",
+			Env:         "CUPERTINO_JOBS",
+			Description: "Registry requests and downloads to run at once",
+			Default:     func() string { return "8" },
+			Validate:    validatePositiveInt,
+		},
+		{
+			Key:         "signature_policy",
+			Env:         "CUPERTINO_SIGNATURE_POLICY",
+			Description: "Package signature checks: off, verify or enforce",
+			Default:     func() string { return signaturePolicyVerify },
+			Validate:    validateSignaturePolicy,
+		},
+		{
+			Key:         "signing_key",
+			Env:         "CUPERTINO_SIGNING_KEY",
+			Description: "Private key used by publish --sign",
+			Default:     defaultSigningKeyPath,
+		},
+		{
+			Key:         "api_key",
+			Env:         "CUPERTINO_API_KEY",
+			Description: "API key used by publish on the default registry",
+			Default:     func() string { return "" },
+			Secret:      true,
+		},
+	}
+}
+
+// configFile is one layer of on-disk configuration.
+type configFile struct {
+	path   string
+	values map[string]string
+}
+
+var (
+	loadedConfig   []*configFile
+	loadedConfigMu sync.Mutex
+)
+
+// configFiles returns the system and user config files, lowest precedence
+// first. Files that don't exist are returned empty.
+func configFiles() []*configFile {
+	loadedConfigMu.Lock()
+	defer loadedConfigMu.Unlock()
+
+	if loadedConfig != nil {
+		return loadedConfig
+	}
+
+	for _, path := range []string{systemConfigPath, userConfigPath()} {
+		file, err := readConfigFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring %s: %v\n", path, err)
+			file = &configFile{path: path, values: make(map[string]string)}
+		}
+		loadedConfig = append(loadedConfig, file)
+	}
+	return loadedConfig
+}
+
+func userConfigPath() string {
+	if path := os.Getenv("CUPERTINO_CONFIG"); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "cupertino", "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "cupertino", "config.json")
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	file := &configFile{path: path, values: make(map[string]string)}
+	if path == "" {
+		return file, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &file.values); err != nil {
+		return nil, fmt.Errorf("parsing: %v", err)
+	}
+	return file, nil
+}
+
+func (file *configFile) write(perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(file.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file.path, append(data, '\n'), perm)
+}
+
+func findConfigSetting(key string) (*configSetting, error) {
+	for i := range configSettings {
+		if configSettings[i].Key == key {
+			return &configSettings[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown config key %q (run 'cupertino config list' to see them all)", key)
+}
+
+// lookupConfig returns the effective value of a setting and where it came from.
+func lookupConfig(setting *configSetting) (value, source string) {
+	if setting.Flag != "" {
+		if f := flag.Lookup(setting.Flag); f != nil {
+			set := false
+			flag.Visit(func(visited *flag.Flag) { set = set || visited.Name == setting.Flag })
+			if set {
+				return f.Value.String(), "flag -" + setting.Flag
+			}
+		}
+	}
+
+	if setting.Env != "" {
+		if value := os.Getenv(setting.Env); value != "" {
+			return value, "env " + setting.Env
+		}
+	}
+
+	files := configFiles()
+	for i := len(files) - 1; i >= 0; i-- {
+		if value, ok := files[i].values[setting.Key]; ok {
+			return value, files[i].path
+		}
+	}
+
+	return setting.Default(), "default"
+}
+
+// configValue returns the effective value of a known config key.
+func configValue(key string) string {
+	setting, err := findConfigSetting(key)
+	if err != nil {
+		panic(err)
+	}
+	value, _ := lookupConfig(setting)
+	return value
+}
+
+func configBool(key string) bool {
+	value, _ := strconv.ParseBool(configValue(key))
+	return value
+}
+
+func configInt(key string) int {
+	value, err := strconv.Atoi(configValue(key))
+	if err != nil {
+		setting, _ := findConfigSetting(key)
+		value, _ = strconv.Atoi(setting.Default())
+	}
+	return value
+}
+
+func configDuration(key string) time.Duration {
+	value, err := time.ParseDuration(configValue(key))
+	if err != nil {
+		setting, _ := findConfigSetting(key)
+		value, _ = time.ParseDuration(setting.Default())
+	}
+	return value
+}
+
+func validateURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", value)
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("%q is not true or false", value)
+	}
+	return nil
+}
+
+func validatePositiveInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 1 {
+		return fmt.Errorf("%q is not a positive whole number", value)
+	}
+	return nil
+}
+
+func validateNonNegativeInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 0 {
+		return fmt.Errorf("%q is not a whole number", value)
+	}
+	return nil
+}
+
+func validateDuration(value string) error {
+	if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+		return fmt.Errorf("%q is not a duration such as 30s or 2m", value)
+	}
+	return nil
+}
+
+func configCommand(args []string) {
+	system := false
+	var rest []string
+	for _, arg := range args {
+		if arg == "--system" {
+			system = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+
+	if len(rest) == 0 {
+		rest = []string{"list"}
+	}
+
+	switch rest[0] {
+	case "list":
+		configList()
+	case "get":
+		if len(rest) < 2 {
+			fmt.Println("Usage: cupertino config get <key>")
+			return
+		}
+		setting, err := findConfigSetting(rest[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		value, _ := lookupConfig(setting)
+		fmt.Println(value)
+	case "set":
+		if len(rest) < 3 {
+			fmt.Println("Usage: cupertino config set <key> <value> [--system]")
+			return
+		}
+		configSet(rest[1], strings.Join(rest[2:], " "), system)
+	case "unset":
+		if len(rest) < 2 {
+			fmt.Println("Usage: cupertino config unset <key> [--system]")
+			return
+		}
+		configSet(rest[1], "", system)
+	default:
+		fmt.Printf("Unknown config command: %s\n", rest[0])
+		fmt.Println("Usage: cupertino config [list|get|set|unset] [--system]")
+		fmt.Println("\nKeys:")
+		for _, setting := range configSettings {
+			fmt.Printf("  %-14s %s (%s)\n", setting.Key, setting.Description, setting.Env)
+		}
+	}
+}
+
+func configList() {
+	for i := range configSettings {
+		setting := &configSettings[i]
+		value, source := lookupConfig(setting)
+		if setting.Secret && value != "" {
+			value = "********"
+		}
+		fmt.Printf("  %-14s %-40s (%s)\n", setting.Key, value, source)
+	}
+
+	// Report keys that are set but not recognised, e.g. from a newer version
+	var unknown []string
+	for _, file := range configFiles() {
+		for key := range file.values {
+			if _, err := findConfigSetting(key); err != nil {
+				unknown = append(unknown, fmt.Sprintf("%s in %s", key, file.path))
+			}
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		fmt.Printf("Warning: unknown key %s\n", key)
+	}
+}
+
+// configSet writes a key to the user config file, or the system file if
+// system is set. An empty value removes the key.
+func configSet(key, value string, system bool) {
+	setting, err := findConfigSetting(key)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if value != "" && setting.Validate != nil {
+		if err := setting.Validate(value); err != nil {
+			fmt.Printf("Error: invalid %s: %v\n", key, err)
+			return
+		}
+	}
+
+	// The user file may hold an API key, so only the system file is world-readable
+	files := configFiles()
+	file, perm := files[len(files)-1], os.FileMode(0600)
+	if system {
+		file, perm = files[0], 0644
+	}
+	if file.path == "" {
+		fmt.Println("Error: could not determine the config file location")
+		return
+	}
+
+	if value == "" {
+		delete(file.values, key)
+	} else {
+		file.values[key] = value
+	}
+
+	if err := file.write(perm); err != nil {
+		fmt.Printf("Error writing %s: %v\n", file.path, err)
+		return
+	}
+
+	if value == "" {
+		fmt.Printf("Unset %s in %s\n", key, file.path)
+	} else {
+		fmt.Printf("Set %s in %s\n", key, file.path)
+	}
+
+	if _, source := lookupConfig(setting); source != file.path && source != "default" {
+		fmt.Printf("Note: %s is currently taken from %s\n", key, source)
+	}
+}
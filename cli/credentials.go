@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credential is a registry token saved by `cupertino login`.
+type credential struct {
+	Token   string    `json:"token"`
+	User    string    `json:"user,omitempty"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// whoamiResponse is the registry's answer to GET /api/whoami.
+type whoamiResponse struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+var (
+	loadedCredentials   map[string]credential
+	loadedCredentialsMu sync.Mutex
+)
+
+// credentialsPath returns the file tokens are stored in, next to the user
+// config file. It is keyed by registry URL.
+func credentialsPath() string {
+	if path := os.Getenv("CUPERTINO_CREDENTIALS"); path != "" {
+		return path
+	}
+	if config := userConfigPath(); config != "" {
+		return filepath.Join(filepath.Dir(config), "credentials.json")
+	}
+	return ""
+}
+
+func loadCredentials() (map[string]credential, error) {
+	loadedCredentialsMu.Lock()
+	defer loadedCredentialsMu.Unlock()
+
+	if loadedCredentials != nil {
+		return loadedCredentials, nil
+	}
+
+	credentials := make(map[string]credential)
+	data, err := os.ReadFile(credentialsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &credentials); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", credentialsPath(), err)
+		}
+	}
+
+	loadedCredentials = credentials
+	return credentials, nil
+}
+
+// saveCredentials writes the credentials file, readable only by the user.
+func saveCredentials(credentials map[string]credential) error {
+	path := credentialsPath()
+	if path == "" {
+		return fmt.Errorf("could not determine the credentials file location")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file, so tighten it explicitly
+	return os.Chmod(path, 0600)
+}
+
+// registryToken returns the token to authenticate to registryURL with: the
+// api_key setting if set and registryURL is the default registry, else the
+// token saved by `cupertino login`.
+func registryToken(registryURL string) string {
+	if apiKey := defaultRegistryAPIKey(registryURL); apiKey != "" {
+		return apiKey
+	}
+
+	credentials, err := loadCredentials()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring credentials: %v\n", err)
+		return ""
+	}
+	return credentials[registryURL].Token
+}
+
+// defaultRegistryAPIKey returns the api_key setting if registryURL is the
+// default registry. The key belongs to that registry alone and is never sent
+// to the others.
+func defaultRegistryAPIKey(registryURL string) string {
+	if registryURL != getRegistryURL() {
+		return ""
+	}
+	return configValue("api_key")
+}
+
+// savedToken returns only the token saved by `cupertino login`, which is
+// what the HTTP client attaches to registry requests automatically.
+func savedToken(registryURL string) string {
+	credentials, err := loadCredentials()
+	if err != nil {
+		return ""
+	}
+	return credentials[registryURL].Token
+}
+
+// loginRegistry returns the registry named by a --registry argument, which
+// may be a configured registry name or a URL, defaulting to the default
+// registry.
+func loginRegistry(args []string) (Registry, []string, error) {
+	var rest []string
+	target := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--registry" && i+1 < len(args):
+			target = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--registry="):
+			target = strings.TrimPrefix(args[i], "--registry=")
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	if target == "" {
+		return Registry{Name: defaultRegistryName, URL: getRegistryURL()}, rest, nil
+	}
+	if registry, ok := findRegistry(target); ok {
+		return registry, rest, nil
+	}
+	if err := validateURL(target); err != nil {
+		return Registry{}, nil, fmt.Errorf("%q is neither a configured registry nor a URL", target)
+	}
+
+	targetURL := strings.TrimSuffix(target, "/")
+	return Registry{Name: registryName(targetURL), URL: targetURL}, rest, nil
+}
+
+// checkToken asks the registry who token belongs to. A registry without
+// /api/whoami can't validate tokens; that returns validated false and no error.
+func checkToken(registryURL, token string) (*whoamiResponse, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, registryURL+"/api/whoami", nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := httpClient(registryURL).Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var who whoamiResponse
+		if err := json.NewDecoder(resp.Body).Decode(&who); err != nil {
+			return nil, false, fmt.Errorf("parsing response: %v", err)
+		}
+		return &who, true, nil
+	case http.StatusNotFound:
+		return nil, false, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, false, fmt.Errorf("the registry rejected the token")
+	default:
+		return nil, false, fmt.Errorf("registry returned HTTP %d", resp.StatusCode)
+	}
+}
+
+// readSecret prompts for a line of input without echoing it when stdin is a
+// terminal.
+func readSecret(label string) string {
+	fmt.Print(label)
+
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Println()
+		}()
+	}
+
+	var line string
+	fmt.Scanln(&line)
+	return strings.TrimSpace(line)
+}
+
+func login(args []string) {
+	registry, rest, err := loginRegistry(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// The token is read from stdin, CUPERTINO_TOKEN or a prompt. --token
+	// still works, but leaves it in shell history and the process list.
+	token := os.Getenv("CUPERTINO_TOKEN")
+	for i := 0; i < len(rest); i++ {
+		switch {
+		case rest[i] == "--token-stdin":
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Printf("Error reading token from stdin: %v\n", err)
+				return
+			}
+			token = strings.TrimSpace(string(data))
+		case rest[i] == "--token" && i+1 < len(rest):
+			fmt.Println("Warning: --token exposes the token in shell history and the process list; use --token-stdin or CUPERTINO_TOKEN instead")
+			token = rest[i+1]
+			i++
+		}
+	}
+	if token == "" {
+		fmt.Printf("Logging in to %s (%s)\n", registry.Name, registry.URL)
+		token = readSecret("Token: ")
+	}
+	if token == "" {
+		fmt.Println("Error: no token given")
+		return
+	}
+
+	who, validated, err := checkToken(registry.URL, token)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if !validated {
+		fmt.Println("Warning: this registry cannot validate tokens; saving it unchecked")
+	}
+
+	credentials, err := loadCredentials()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	cred := credential{Token: token, SavedAt: time.Now()}
+	if who != nil {
+		cred.User = who.User
+	}
+	credentials[registry.URL] = cred
+
+	if err := saveCredentials(credentials); err != nil {
+		fmt.Printf("Error saving credentials: %v\n", err)
+		return
+	}
+
+	if cred.User != "" {
+		fmt.Printf("✅ Logged in to %s as %s\n", registry.Name, cred.User)
+	} else {
+		fmt.Printf("✅ Saved token for %s\n", registry.Name)
+	}
+}
+
+func logout(args []string) {
+	registry, _, err := loginRegistry(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	credentials, err := loadCredentials()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if _, ok := credentials[registry.URL]; !ok {
+		fmt.Printf("Not logged in to %s\n", registry.Name)
+		return
+	}
+
+	delete(credentials, registry.URL)
+	if err := saveCredentials(credentials); err != nil {
+		fmt.Printf("Error saving credentials: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Logged out of %s\n", registry.Name)
+}
+
+// whoami shows, for every configured registry and any other registry with a
+// saved token, which credentials would be used and whether they still work.
+func whoami() {
+	credentials, err := loadCredentials()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	registries := getRegistries()
+	known := make(map[string]bool)
+	for _, registry := range registries {
+		known[registry.URL] = true
+	}
+	var others []string
+	for registryURL := range credentials {
+		if !known[registryURL] {
+			others = append(others, registryURL)
+		}
+	}
+	sort.Strings(others)
+	for _, registryURL := range others {
+		registries = append(registries, Registry{Name: "-", URL: registryURL})
+	}
+
+	for _, registry := range registries {
+		token, source := credentials[registry.URL].Token, "saved token"
+		if apiKey := defaultRegistryAPIKey(registry.URL); apiKey != "" {
+			token, source = apiKey, "api_key setting"
+		}
+
+		if token == "" {
+			fmt.Printf("  %-12s %s: not logged in\n", registry.Name, registry.URL)
+			continue
+		}
+
+		who, validated, err := checkToken(registry.URL, token)
+		switch {
+		case err != nil:
+			fmt.Printf("  %-12s %s: %s, %v\n", registry.Name, registry.URL, source, err)
+		case !validated:
+			fmt.Printf("  %-12s %s: %s (the registry cannot check it)\n", registry.Name, registry.URL, source)
+		default:
+			fmt.Printf("  %-12s %s: logged in as %s (%s)\n", registry.Name, registry.URL, who.User, source)
+		}
+	}
+}
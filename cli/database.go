@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -26,6 +31,10 @@ type SQLitePackageDB struct {
 }
 
 func NewSQLitePackageDB(dbPath string) (*SQLitePackageDB, error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
+
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, err
@@ -43,52 +52,248 @@ func NewSQLitePackageDB(dbPath string) (*SQLitePackageDB, error) {
 	return pkgDB, err
 }
 
-func (db *SQLitePackageDB) initSchema() error {
-	schema := `
+const packagesSchema = `
     CREATE TABLE IF NOT EXISTS packages (
-        name TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
         version TEXT NOT NULL,
         description TEXT,
         homepage TEXT,
         license TEXT,
         install_path TEXT NOT NULL,
-        install_date DATETIME NOT NULL
+        install_date DATETIME NOT NULL,
+        active INTEGER NOT NULL DEFAULT 0, -- 1 for the version linked into bin/
+        retained INTEGER NOT NULL DEFAULT 0, -- 1 for a replaced version kept for rollback
+        registry TEXT NOT NULL DEFAULT '', -- URL of the registry it was installed from
+        explicit INTEGER NOT NULL DEFAULT 0, -- 1 if the user asked for the package, not just a dependent
+        PRIMARY KEY (name, version)
     );
 
     CREATE TABLE IF NOT EXISTS package_files (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         package_name TEXT NOT NULL,
+        package_version TEXT NOT NULL,
         file_path TEXT NOT NULL,
-        FOREIGN KEY (package_name) REFERENCES packages(name) ON DELETE CASCADE
+        sha256 TEXT NOT NULL DEFAULT '', -- '' if installed before checksums were recorded
+        size INTEGER NOT NULL DEFAULT 0,
+        mode INTEGER NOT NULL DEFAULT 0,
+        FOREIGN KEY (package_name, package_version) REFERENCES packages(name, version) ON DELETE CASCADE
     );
 
     CREATE TABLE IF NOT EXISTS dependencies (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         package_name TEXT NOT NULL,
+        package_version TEXT NOT NULL,
         dependency_name TEXT NOT NULL,
         version_constraint TEXT, -- ">=2.0", "^1.5.0"
-        FOREIGN KEY (package_name) REFERENCES packages(name) ON DELETE CASCADE
+        FOREIGN KEY (package_name, package_version) REFERENCES packages(name, version) ON DELETE CASCADE
     );
 
     CREATE TABLE IF NOT EXISTS package_scripts (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         package_name TEXT NOT NULL,
+        package_version TEXT NOT NULL,
         script_type TEXT NOT NULL, -- "pre_install", "post_install", etc.
         script_content TEXT NOT NULL,
-        FOREIGN KEY (package_name) REFERENCES packages(name) ON DELETE CASCADE
+        FOREIGN KEY (package_name, package_version) REFERENCES packages(name, version) ON DELETE CASCADE
+    );
+
+    CREATE TABLE IF NOT EXISTS package_history (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        package_name TEXT NOT NULL,
+        from_version TEXT NOT NULL, -- "" for a first install
+        to_version TEXT NOT NULL,
+        action TEXT NOT NULL, -- "install", "upgrade", "downgrade", "switch", "rollback"
+        changed_at DATETIME NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS pins (
+        package_name TEXT PRIMARY KEY,
+        version_constraint TEXT NOT NULL, -- "1.4.2", "~1.4"
+        pinned_at DATETIME NOT NULL
     );
     `
 
-	_, err := db.db.Exec(schema)
+// migrations upgrade an existing database one schema version at a time:
+// migrations[i] takes a database at user_version i to i+1.
+var migrations = []func(tx *sql.Tx) error{
+	migrateToVersionedPackages,
+	migrateAddRetained,
+	migrateAddRegistry,
+	migrateAddFileChecksums,
+	migrateAddExplicit,
+}
+
+func (db *SQLitePackageDB) initSchema() error {
+	var version int
+	if err := db.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	var tables int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'packages'").Scan(&tables); err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if tables > 0 {
+		for next := version; next < len(migrations); next++ {
+			if err := migrations[next](tx); err != nil {
+				return fmt.Errorf("migrating database to version %d: %v", next+1, err)
+			}
+		}
+	}
+
+	if _, err := tx.Exec(packagesSchema); err != nil {
+		return err
+	}
+	// Setting user_version takes a write lock even when it doesn't change, and
+	// then opening the database fails while another process is installing
+	if version != len(migrations) {
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// migrateToVersionedPackages moves from one row per package name to one row
+// per installed version, marking the existing rows active.
+func migrateToVersionedPackages(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE packages RENAME TO packages_v0",
+		"ALTER TABLE package_files RENAME TO package_files_v0",
+		"ALTER TABLE dependencies RENAME TO dependencies_v0",
+		"ALTER TABLE package_scripts RENAME TO package_scripts_v0",
+		packagesSchema,
+		`INSERT INTO packages (name, version, description, homepage, license, install_path, install_date, active)
+            SELECT name, version, description, homepage, license, install_path, install_date, 1 FROM packages_v0`,
+		`INSERT INTO package_files (package_name, package_version, file_path)
+            SELECT f.package_name, p.version, f.file_path FROM package_files_v0 f
+            JOIN packages_v0 p ON p.name = f.package_name ORDER BY f.id`,
+		`INSERT INTO dependencies (package_name, package_version, dependency_name, version_constraint)
+            SELECT d.package_name, p.version, d.dependency_name, d.version_constraint FROM dependencies_v0 d
+            JOIN packages_v0 p ON p.name = d.package_name ORDER BY d.id`,
+		`INSERT INTO package_scripts (package_name, package_version, script_type, script_content)
+            SELECT s.package_name, p.version, s.script_type, s.script_content FROM package_scripts_v0 s
+            JOIN packages_v0 p ON p.name = s.package_name ORDER BY s.id`,
+		"DROP TABLE package_files_v0",
+		"DROP TABLE dependencies_v0",
+		"DROP TABLE package_scripts_v0",
+		"DROP TABLE packages_v0",
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateAddRetained(tx *sql.Tx) error {
+	return addColumn(tx, "packages", "retained", "INTEGER NOT NULL DEFAULT 0")
+}
+
+func migrateAddRegistry(tx *sql.Tx) error {
+	return addColumn(tx, "packages", "registry", "TEXT NOT NULL DEFAULT ''")
+}
+
+func migrateAddFileChecksums(tx *sql.Tx) error {
+	columns := [][2]string{
+		{"sha256", "TEXT NOT NULL DEFAULT ''"},
+		{"size", "INTEGER NOT NULL DEFAULT 0"},
+		{"mode", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, column := range columns {
+		if err := addColumn(tx, "package_files", column[0], column[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateAddExplicit records which packages were asked for by the user.
+// Installs before this weren't tracked, so packages nothing depends on are
+// assumed to have been.
+func migrateAddExplicit(tx *sql.Tx) error {
+	if err := addColumn(tx, "packages", "explicit", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+        UPDATE packages SET explicit = 1 WHERE name NOT IN (
+            SELECT d.dependency_name FROM dependencies d
+            JOIN packages p ON p.name = d.package_name AND p.version = d.package_version
+            WHERE p.active = 1)`)
+	return err
+}
+
+// addColumn adds a column to an existing table. Migrations that recreate a
+// table do so from the current schema, so the column may already be there.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
+// IntegrityCheck runs SQLite's consistency check on the database file.
+func (db *SQLitePackageDB) IntegrityCheck() error {
+	rows, err := db.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Get returns the active version of a package, or the most recently
+// installed version if none is active.
 func (db *SQLitePackageDB) Get(name string) (*InstalledPackage, error) {
+	var version string
+	err := db.db.QueryRow(`
+        SELECT version FROM packages WHERE name = ?
+        ORDER BY active DESC, install_date DESC LIMIT 1`, name).Scan(&version)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetVersion(name, version)
+}
+
+func (db *SQLitePackageDB) GetVersion(name, version string) (*InstalledPackage, error) {
 	pkg := &InstalledPackage{}
 
 	err := db.db.QueryRow(`
-        SELECT name, version, description, homepage, license, install_path, install_date
-        FROM packages WHERE name = ?`, name).Scan(
+        SELECT name, version, description, homepage, license, install_path, install_date, active, retained, registry, explicit
+        FROM packages WHERE name = ? AND version = ?`, name, version).Scan(
 		&pkg.Name,
 		&pkg.Version,
 		&pkg.Description,
@@ -96,27 +301,38 @@ func (db *SQLitePackageDB) Get(name string) (*InstalledPackage, error) {
 		&pkg.License,
 		&pkg.InstallPath,
 		&pkg.InstallDate,
+		&pkg.Active,
+		&pkg.Retained,
+		&pkg.Registry,
+		&pkg.Explicit,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	depRows, err := db.db.Query("SELECT file_path FROM package_files WHERE package_name = ?", name)
+	fileRows, err := db.db.Query("SELECT file_path, sha256, size, mode FROM package_files WHERE package_name = ? AND package_version = ? ORDER BY id", name, version)
 	if err != nil {
 		return nil, err
 	}
-	defer depRows.Close()
+	defer fileRows.Close()
 
-	for depRows.Next() {
+	for fileRows.Next() {
 		var filePath string
-		if err := depRows.Scan(&filePath); err != nil {
+		var checksum FileChecksum
+		if err := fileRows.Scan(&filePath, &checksum.SHA256, &checksum.Size, &checksum.Mode); err != nil {
 			return nil, err
 		}
 		pkg.InstalledFiles = append(pkg.InstalledFiles, filePath)
+		if checksum.SHA256 != "" {
+			if pkg.FileChecksums == nil {
+				pkg.FileChecksums = make(map[string]FileChecksum)
+			}
+			pkg.FileChecksums[filePath] = checksum
+		}
 	}
 
 	pkg.Dependencies = make(map[string]string)
-	depRows, err = db.db.Query("SELECT dependency_name, version_constraint FROM dependencies WHERE package_name = ?", name)
+	depRows, err := db.db.Query("SELECT dependency_name, version_constraint FROM dependencies WHERE package_name = ? AND package_version = ?", name, version)
 	if err != nil {
 		return nil, err
 	}
@@ -130,23 +346,43 @@ func (db *SQLitePackageDB) Get(name string) (*InstalledPackage, error) {
 		pkg.Dependencies[depName] = constraint
 	}
 
+	scriptRows, err := db.db.Query("SELECT script_type, script_content FROM package_scripts WHERE package_name = ? AND package_version = ? ORDER BY id", name, version)
+	if err != nil {
+		return nil, err
+	}
+	defer scriptRows.Close()
+
+	for scriptRows.Next() {
+		var scriptType, content string
+		if err := scriptRows.Scan(&scriptType, &content); err != nil {
+			return nil, err
+		}
+		setScripts(&pkg.Package, scriptType, append(getScripts(&pkg.Package, scriptType), content))
+	}
+
 	return pkg, nil
 }
 
+// List returns one entry per installed package: the active version.
 func (db *SQLitePackageDB) List() ([]*InstalledPackage, error) {
-	rows, err := db.db.Query("SELECT name FROM packages ORDER BY name")
+	rows, err := db.db.Query("SELECT DISTINCT name FROM packages ORDER BY name")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var packages []*InstalledPackage
+	var names []string
 	for rows.Next() {
 		var name string
 		if err := rows.Scan(&name); err != nil {
 			return nil, err
 		}
+		names = append(names, name)
+	}
+	rows.Close()
 
+	var packages []*InstalledPackage
+	for _, name := range names {
 		pkg, err := db.Get(name)
 		if err != nil {
 			return nil, err
@@ -157,9 +393,219 @@ func (db *SQLitePackageDB) List() ([]*InstalledPackage, error) {
 	return packages, nil
 }
 
+// ListVersions returns every installed version of a package, newest install first.
+func (db *SQLitePackageDB) ListVersions(name string) ([]*InstalledPackage, error) {
+	versions, err := db.GetInstalledVersions(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []*InstalledPackage
+	for _, version := range versions {
+		pkg, err := db.GetVersion(name, version)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, pkg)
+	}
+
+	return packages, nil
+}
+
+// ListAll returns every installed version of every package.
+func (db *SQLitePackageDB) ListAll() ([]*InstalledPackage, error) {
+	installed, err := db.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []*InstalledPackage
+	for _, pkg := range installed {
+		versions, err := db.ListVersions(pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, versions...)
+	}
+
+	return packages, nil
+}
+
+// Remove deletes every installed version of a package.
 func (db *SQLitePackageDB) Remove(name string) error {
-	// SQLite handles cascade deletes for related tables in the above schema
-	_, err := db.db.Exec("DELETE FROM packages WHERE name = ?", name)
+	return db.RemoveVersion(name, "")
+}
+
+// RemoveVersion deletes one installed version, or all of them if version is "".
+func (db *SQLitePackageDB) RemoveVersion(name, version string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deletePackageRows(tx, name, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deletePackageRows removes a package version (or all versions if version is
+// "") and its related rows. Foreign keys are not enabled on the connection,
+// so the cascades in the schema never fire.
+func deletePackageRows(tx *sql.Tx, name, version string) error {
+	filter, args := "package_name = ?", []any{name}
+	if version != "" {
+		filter, args = "package_name = ? AND package_version = ?", []any{name, version}
+	}
+
+	for _, table := range []string{"package_files", "dependencies", "package_scripts"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE "+filter, args...); err != nil {
+			return err
+		}
+	}
+
+	if version == "" {
+		_, err := tx.Exec("DELETE FROM packages WHERE name = ?", name)
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM packages WHERE name = ? AND version = ?", name, version)
+	return err
+}
+
+// SetActive marks version as the active version of a package.
+func (db *SQLitePackageDB) SetActive(name, version string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setActiveVersion(tx, name, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setActiveVersion marks version active. An activated version is no longer
+// considered retained for rollback.
+func setActiveVersion(tx *sql.Tx, name, version string) error {
+	result, err := tx.Exec(`
+        UPDATE packages SET active = (version = ?), retained = (retained AND version != ?)
+        WHERE name = ?`, version, version, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("package %s not installed", name)
+	}
+	return nil
+}
+
+// GetRegistry returns the URL of the registry the active version of a package
+// was installed from, or "" if unknown.
+func (db *SQLitePackageDB) GetRegistry(name string) string {
+	query := "SELECT registry FROM packages WHERE name = ? ORDER BY active DESC, install_date DESC LIMIT 1"
+	var registry string
+	if err := db.db.QueryRow(query, name).Scan(&registry); err != nil {
+		return ""
+	}
+	return registry
+}
+
+// Backup writes a consistent copy of the database to path.
+func (db *SQLitePackageDB) Backup(path string) error {
+	_, err := db.db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// Restore replaces the contents of every table with those in a copy made by
+// Backup. It goes through SQLite rather than copying the file, so other open
+// connections see either the old or the restored contents.
+func (db *SQLitePackageDB) Restore(path string) error {
+	ctx := context.Background()
+
+	// ATTACH applies to a single connection, so hold one for the restore
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS backup", path); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE backup")
+
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM backup.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM main.%q", table)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO main.%q SELECT * FROM backup.%q", table, table)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetExplicit records that the user asked for a package by name, rather than
+// it being installed only as a dependency.
+func (db *SQLitePackageDB) SetExplicit(name string) error {
+	_, err := db.db.Exec("UPDATE packages SET explicit = 1 WHERE name = ?", name)
+	return err
+}
+
+// SetFileChecksums updates the checksums recorded for a package version's files.
+func (db *SQLitePackageDB) SetFileChecksums(name, version string, checksums map[string]FileChecksum) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for filePath, checksum := range checksums {
+		_, err := tx.Exec(`
+            UPDATE package_files SET sha256 = ?, size = ?, mode = ?
+            WHERE package_name = ? AND package_version = ? AND file_path = ?`,
+			checksum.SHA256, checksum.Size, checksum.Mode, name, version, filePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetRetained marks an inactive version as kept so it can be rolled back to.
+func (db *SQLitePackageDB) SetRetained(name, version string, retained bool) error {
+	_, err := db.db.Exec("UPDATE packages SET retained = ? WHERE name = ? AND version = ?", retained, name, version)
 	return err
 }
 
@@ -173,8 +619,9 @@ func (db *SQLitePackageDB) IsInstalledVersion(name, version string) bool {
 	return count > 0
 }
 
+// GetInstalledVersion returns the active version of a package.
 func (db *SQLitePackageDB) GetInstalledVersion(name string) (string, error) {
-	query := "SELECT version FROM packages WHERE name = ? LIMIT 1"
+	query := "SELECT version FROM packages WHERE name = ? ORDER BY active DESC, install_date DESC LIMIT 1"
 	var version string
 	err := db.db.QueryRow(query, name).Scan(&version)
 	if err != nil {
@@ -213,8 +660,8 @@ func (db *SQLitePackageDB) HasAnyVersion(name string) bool {
 func (db *SQLitePackageDB) GetDependents(packageName string) ([]*InstalledPackage, error) {
 	rows, err := db.db.Query(`
         SELECT DISTINCT p.name FROM packages p
-        JOIN dependencies d on p.name = d.package_name
-        WHERE d.dependency_name = ?`, packageName)
+        JOIN dependencies d ON p.name = d.package_name AND p.version = d.package_version
+        WHERE d.dependency_name = ? AND p.active = 1`, packageName)
 	if err != nil {
 		return nil, err
 	}
@@ -238,7 +685,10 @@ func (db *SQLitePackageDB) GetDependents(packageName string) ([]*InstalledPackag
 }
 
 func (db *SQLitePackageDB) GetDependencies(packageName string) ([]*InstalledPackage, error) {
-	rows, err := db.db.Query("SELECT dependency_name FROM dependencies WHERE package_name = ?", packageName)
+	rows, err := db.db.Query(`
+        SELECT d.dependency_name FROM dependencies d
+        JOIN packages p ON p.name = d.package_name AND p.version = d.package_version
+        WHERE d.package_name = ? AND p.active = 1`, packageName)
 	if err != nil {
 		return nil, err
 	}
@@ -263,6 +713,7 @@ func (db *SQLitePackageDB) GetDependencies(packageName string) ([]*InstalledPack
 	return dependencies, nil
 }
 
+// Install records a package version and makes it the active version.
 func (db *SQLitePackageDB) Install(pkg *InstalledPackage) error {
 	tx, err := db.db.Begin()
 	if err != nil {
@@ -270,10 +721,21 @@ func (db *SQLitePackageDB) Install(pkg *InstalledPackage) error {
 	}
 	defer tx.Rollback()
 
+	// Reinstalling or upgrading a package keeps it explicit
+	var explicit bool
+	err = tx.QueryRow("SELECT COALESCE(MAX(explicit), 0) FROM packages WHERE name = ?", pkg.Name).Scan(&explicit)
+	if err != nil {
+		return err
+	}
+
+	if err := deletePackageRows(tx, pkg.Name, pkg.Version); err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(`
-        INSERT OR REPLACE INTO packages
-        (name, version, description, homepage, license, install_path, install_date)
-        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+        INSERT INTO packages
+        (name, version, description, homepage, license, install_path, install_date, registry, explicit)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		pkg.Name,
 		pkg.Version,
 		pkg.Description,
@@ -281,15 +743,18 @@ func (db *SQLitePackageDB) Install(pkg *InstalledPackage) error {
 		pkg.License,
 		pkg.InstallPath,
 		pkg.InstallDate,
+		pkg.Registry,
+		explicit || pkg.Explicit,
 	)
 	if err != nil {
 		return err
 	}
 
 	for _, filePath := range pkg.InstalledFiles {
+		checksum := pkg.FileChecksums[filePath]
 		_, err := tx.Exec(`
-            INSERT INTO package_files (package_name, file_path)
-            VALUES (?, ?)`, pkg.Name, filePath)
+            INSERT INTO package_files (package_name, package_version, file_path, sha256, size, mode)
+            VALUES (?, ?, ?, ?, ?, ?)`, pkg.Name, pkg.Version, filePath, checksum.SHA256, checksum.Size, checksum.Mode)
 		if err != nil {
 			return err
 		}
@@ -297,16 +762,130 @@ func (db *SQLitePackageDB) Install(pkg *InstalledPackage) error {
 
 	for depName, constraint := range pkg.Dependencies {
 		_, err := tx.Exec(`
-            INSERT INTO dependencies (package_name, dependency_name, version_constraint)
-            VALUES (?, ?, ?)`, pkg.Name, depName, constraint)
+            INSERT INTO dependencies (package_name, package_version, dependency_name, version_constraint)
+            VALUES (?, ?, ?, ?)`, pkg.Name, pkg.Version, depName, constraint)
 		if err != nil {
 			return err
 		}
 	}
 
+	for _, hook := range lifecycleHooks {
+		for _, script := range getScripts(&pkg.Package, hook) {
+			_, err := tx.Exec(`
+            INSERT INTO package_scripts (package_name, package_version, script_type, script_content)
+            VALUES (?, ?, ?, ?)`, pkg.Name, pkg.Version, hook, script)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := setActiveVersion(tx, pkg.Name, pkg.Version); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
+// RecordHistory logs a change of a package's active version.
+func (db *SQLitePackageDB) RecordHistory(name, fromVersion, toVersion, action string) error {
+	_, err := db.db.Exec(`
+        INSERT INTO package_history (package_name, from_version, to_version, action, changed_at)
+        VALUES (?, ?, ?, ?, ?)`, name, fromVersion, toVersion, action, time.Now())
+	return err
+}
+
+// History returns the recorded version changes for a package, or for every
+// package if name is "", oldest first.
+func (db *SQLitePackageDB) History(name string) ([]*HistoryEntry, error) {
+	query := "SELECT package_name, from_version, to_version, action, changed_at FROM package_history"
+	var args []any
+	if name != "" {
+		query += " WHERE package_name = ?"
+		args = append(args, name)
+	}
+	query += " ORDER BY id"
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*HistoryEntry
+	for rows.Next() {
+		entry := &HistoryEntry{}
+		if err := rows.Scan(&entry.Name, &entry.FromVersion, &entry.ToVersion, &entry.Action, &entry.Date); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
+
+// PreviousVersion returns the version a package was most recently changed
+// from to reach version, or "" if there is no record of one.
+func (db *SQLitePackageDB) PreviousVersion(name, version string) (string, error) {
+	var previous string
+	err := db.db.QueryRow(`
+        SELECT from_version FROM package_history
+        WHERE package_name = ? AND to_version = ? AND from_version != ''
+        ORDER BY id DESC LIMIT 1`, name, version).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return previous, err
+}
+
+// Pin holds a package within constraint, replacing any existing pin.
+func (db *SQLitePackageDB) Pin(name, constraint string) error {
+	_, err := db.db.Exec(`
+        INSERT OR REPLACE INTO pins (package_name, version_constraint, pinned_at)
+        VALUES (?, ?, ?)`, name, constraint, time.Now())
+	return err
+}
+
+// Unpin removes a package's pin. It reports whether the package was pinned.
+func (db *SQLitePackageDB) Unpin(name string) (bool, error) {
+	result, err := db.db.Exec("DELETE FROM pins WHERE package_name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetPin returns the constraint a package is pinned to, or "" if it is not pinned.
+func (db *SQLitePackageDB) GetPin(name string) (string, error) {
+	var constraint string
+	err := db.db.QueryRow("SELECT version_constraint FROM pins WHERE package_name = ?", name).Scan(&constraint)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return constraint, err
+}
+
+// Pins returns every pinned package name and its constraint.
+func (db *SQLitePackageDB) Pins() (map[string]string, error) {
+	rows, err := db.db.Query("SELECT package_name, version_constraint FROM pins")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := make(map[string]string)
+	for rows.Next() {
+		var name, constraint string
+		if err := rows.Scan(&name, &constraint); err != nil {
+			return nil, err
+		}
+		pins[name] = constraint
+	}
+
+	return pins, rows.Err()
+}
+
 func (db *SQLitePackageDB) Close() error {
 	return db.db.Close()
 }
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// depGraph is a package and everything it depends on, either as the resolver
+// would install it or as it is installed now.
+type depGraph struct {
+	root      *Package
+	packages  map[string]*Package // by name; a dependency missing here isn't installed
+	installed map[string]string   // name -> active installed version
+}
+
+// deps shows what a package depends on without installing anything.
+func deps(args []string) {
+	dot, installedOnly := false, false
+	var spec string
+	for _, arg := range args {
+		switch arg {
+		case "--dot":
+			dot = true
+		case "--installed":
+			installedOnly = true
+		default:
+			spec = arg
+		}
+	}
+	if spec == "" {
+		fmt.Println("Error: deps requires a package name")
+		fmt.Println("Usage: cupertino deps <package>[@version] [--dot] [--installed]")
+		return
+	}
+
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	installed, err := activePackages(db)
+	if err != nil {
+		fmt.Printf("Error reading installed packages: %v\n", err)
+		return
+	}
+
+	var graph *depGraph
+	if installedOnly {
+		graph, err = installedDepGraph(db, installed, spec)
+	} else {
+		graph, err = resolvedDepGraph(spec)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	graph.installed = make(map[string]string, len(installed))
+	for name, pkg := range installed {
+		graph.installed[name] = pkg.Version
+	}
+
+	if dot {
+		graph.printDot()
+	} else {
+		graph.printTree()
+	}
+}
+
+// activePackages returns the active version of every installed package.
+func activePackages(db *SQLitePackageDB) (map[string]*InstalledPackage, error) {
+	packages, err := db.List()
+	if err != nil {
+		return nil, err
+	}
+
+	active := make(map[string]*InstalledPackage, len(packages))
+	for _, pkg := range packages {
+		active[pkg.Name] = pkg
+	}
+	return active, nil
+}
+
+// resolvedDepGraph resolves spec against the registry the way install would.
+func resolvedDepGraph(spec string) (*depGraph, error) {
+	name, version := parsePackageSpec(spec)
+	if version == "" {
+		version = loadPins()[name]
+	}
+
+	regPkg, err := resolvePackageSpec(name, version)
+	if err != nil {
+		return nil, err
+	}
+	root := regPkg.toPackage()
+
+	result, err := ResolveDependencies(root)
+	if err != nil {
+		return nil, fmt.Errorf("dependency resolution failed: %v", err)
+	}
+
+	graph := &depGraph{root: root, packages: make(map[string]*Package)}
+	for _, pkg := range result.Packages {
+		graph.packages[pkg.Name] = pkg
+	}
+	return graph, nil
+}
+
+// installedDepGraph follows the dependencies recorded for spec in the
+// database. Dependencies resolve to their active installed version.
+func installedDepGraph(db *SQLitePackageDB, installed map[string]*InstalledPackage, spec string) (*depGraph, error) {
+	name, version := parsePackageSpec(spec)
+
+	var root *InstalledPackage
+	var err error
+	if version != "" {
+		root, err = db.GetVersion(name, version)
+	} else {
+		root, err = db.Get(name)
+	}
+	if err != nil {
+		if version != "" {
+			return nil, fmt.Errorf("%s v%s is not installed", name, version)
+		}
+		return nil, fmt.Errorf("package '%s' is not installed", name)
+	}
+
+	graph := &depGraph{root: &root.Package, packages: make(map[string]*Package)}
+	graph.packages[root.Name] = &root.Package
+
+	queue := []*Package{&root.Package}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for depName := range pkg.Dependencies {
+			if _, seen := graph.packages[depName]; seen {
+				continue
+			}
+			if dep, ok := installed[depName]; ok {
+				graph.packages[depName] = &dep.Package
+				queue = append(queue, &dep.Package)
+			}
+		}
+	}
+
+	return graph, nil
+}
+
+func sortedDependencies(pkg *Package) []string {
+	names := make([]string, 0, len(pkg.Dependencies))
+	for name := range pkg.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// installedMark describes whether pkg is installed, for the tree view.
+func (g *depGraph) installedMark(pkg *Package) string {
+	switch version, ok := g.installed[pkg.Name]; {
+	case !ok:
+		return ""
+	case version == pkg.Version:
+		return " [installed]"
+	default:
+		return fmt.Sprintf(" [v%s installed]", version)
+	}
+}
+
+// printTree prints the graph as a tree. A package reached a second time is
+// not expanded again.
+func (g *depGraph) printTree() {
+	fmt.Printf("%s v%s%s\n", g.root.Name, g.root.Version, g.installedMark(g.root))
+
+	expanded := map[string]bool{g.root.Name: true}
+	var walk func(pkg *Package, indent string)
+	walk = func(pkg *Package, indent string) {
+		names := sortedDependencies(pkg)
+		for i, name := range names {
+			branch, childIndent := "├── ", indent+"│   "
+			if i == len(names)-1 {
+				branch, childIndent = "└── ", indent+"    "
+			}
+			constraint := pkg.Dependencies[name]
+
+			dep, ok := g.packages[name]
+			if !ok {
+				fmt.Printf("%s%s%s (%s) not installed\n", indent, branch, name, constraint)
+				continue
+			}
+
+			line := fmt.Sprintf("%s%s%s v%s (%s)%s", indent, branch, name, dep.Version, constraint, g.installedMark(dep))
+			if expanded[name] {
+				if len(dep.Dependencies) > 0 {
+					line += " (see above)"
+				}
+				fmt.Println(line)
+				continue
+			}
+			fmt.Println(line)
+
+			expanded[name] = true
+			walk(dep, childIndent)
+		}
+	}
+	walk(g.root, "")
+}
+
+// printDot prints the graph in Graphviz format, with installed packages
+// filled in and each edge labelled with its constraint.
+func (g *depGraph) printDot() {
+	node := func(name string) string {
+		if pkg, ok := g.packages[name]; ok {
+			return dotQuote(fmt.Sprintf("%s v%s", pkg.Name, pkg.Version))
+		}
+		return dotQuote(name)
+	}
+
+	names := make([]string, 0, len(g.packages))
+	for name := range g.packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("digraph %s {\n", dotQuote(g.root.Name))
+	fmt.Println("  node [shape=box];")
+	for _, name := range names {
+		pkg := g.packages[name]
+		if version, ok := g.installed[name]; ok && version == pkg.Version {
+			fmt.Printf("  %s [style=filled, fillcolor=lightgrey];\n", node(name))
+		}
+	}
+	for _, name := range names {
+		pkg := g.packages[name]
+		for _, depName := range sortedDependencies(pkg) {
+			if _, ok := g.packages[depName]; !ok {
+				fmt.Printf("  %s [style=dashed];\n", node(depName))
+			}
+			fmt.Printf("  %s -> %s [label=%s];\n", node(name), node(depName), dotQuote(pkg.Dependencies[depName]))
+		}
+	}
+	fmt.Println("}")
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// staleStagingAge is how old a staging dir must be before doctor assumes the
+// install that created it has died rather than still running.
+const staleStagingAge = time.Hour
+
+// doctorFinding is a problem found by a doctor check.
+type doctorFinding struct {
+	problem    string
+	details    []string
+	suggestion string
+	fix        func() error // nil if it can't be repaired automatically
+}
+
+// doctor checks the database, the install tree, bin/ and the registries for
+// problems, and with --fix repairs the ones that are safe to repair.
+func doctor(args []string) {
+	fix := false
+	for _, arg := range args {
+		if arg == "--fix" {
+			fix = true
+		}
+	}
+
+	fmt.Printf("🩺 Checking %s\n\n", getCupertinoDir())
+
+	problems, fixed, fixable := 0, 0, 0
+	report := func(passed string, findings []doctorFinding) {
+		if len(findings) == 0 {
+			fmt.Printf("✅ %s\n", passed)
+			return
+		}
+
+		for _, finding := range findings {
+			problems++
+			fmt.Printf("❌ %s\n", finding.problem)
+			for _, detail := range finding.details {
+				fmt.Printf("   %s\n", detail)
+			}
+			fmt.Printf("   Fix: %s\n", finding.suggestion)
+
+			if finding.fix == nil {
+				continue
+			}
+			if !fix {
+				fixable++
+				continue
+			}
+			if err := finding.fix(); err != nil {
+				fmt.Printf("   Could not fix: %v\n", err)
+				continue
+			}
+			fmt.Println("   🔧 Fixed")
+			fixed++
+		}
+	}
+
+	var db *SQLitePackageDB
+	if _, err := os.Stat(getDatabasePath()); os.IsNotExist(err) {
+		fmt.Println("✅ packages.db doesn't exist yet; nothing is installed")
+	} else {
+		var findings []doctorFinding
+		db, findings = checkDatabase()
+		report("packages.db opens and is consistent", findings)
+	}
+
+	if db != nil {
+		defer db.Close()
+
+		packages, err := db.ListAll()
+		if err != nil {
+			report("", []doctorFinding{{
+				problem:    fmt.Sprintf("could not read installed packages: %v", err),
+				suggestion: "move packages.db aside and reinstall your packages",
+			}})
+		} else {
+			report("every installed package is on disk", checkInstallPaths(db, packages))
+			report("no unowned files in packages/", checkUnownedFiles(packages))
+			report("bin/ links are intact", checkBinLinks(packages))
+		}
+	}
+
+	report("bin/ is on PATH", checkPath())
+
+	if isOffline() {
+		fmt.Println("⏭️  Skipping registry checks (offline)")
+	} else {
+		for _, registry := range getRegistries() {
+			report(fmt.Sprintf("registry %s (%s) is healthy", registry.Name, registry.URL), checkRegistry(registry))
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case problems == 0:
+		fmt.Println("No problems found")
+		return
+	case fix:
+		fmt.Printf("%d problem(s) found, %d fixed\n", problems, fixed)
+	default:
+		fmt.Printf("%d problem(s) found\n", problems)
+		if fixable > 0 {
+			fmt.Printf("Run 'cupertino doctor --fix' to repair %d of them\n", fixable)
+		}
+	}
+
+	if problems > fixed {
+		os.Exit(1)
+	}
+}
+
+// checkDatabase opens packages.db, returning nil if it can't be used.
+func checkDatabase() (*SQLitePackageDB, []doctorFinding) {
+	path := getDatabasePath()
+	db, err := NewSQLitePackageDB(path)
+	if err == nil {
+		err = db.IntegrityCheck()
+		if err != nil {
+			db.Close()
+		}
+	}
+	if err != nil {
+		return nil, []doctorFinding{{
+			problem:    fmt.Sprintf("%s can't be used: %v", path, err),
+			suggestion: "restore it from a backup, or move it aside and reinstall your packages",
+		}}
+	}
+
+	return db, nil
+}
+
+// checkInstallPaths finds packages whose install directory has been deleted.
+// The fix forgets them, since there is nothing left on disk to repair.
+func checkInstallPaths(db *SQLitePackageDB, packages []*InstalledPackage) []doctorFinding {
+	var findings []doctorFinding
+	for _, pkg := range packages {
+		if _, err := os.Stat(pkg.InstallPath); !os.IsNotExist(err) {
+			continue
+		}
+
+		findings = append(findings, doctorFinding{
+			problem:    fmt.Sprintf("%s v%s is in the database but %s is missing", pkg.Name, pkg.Version, pkg.InstallPath),
+			suggestion: fmt.Sprintf("reinstall it with 'cupertino install %s@%s', or remove it from the database", pkg.Name, pkg.Version),
+			fix: func() error {
+				removeSymlinks(pkg)
+				return db.RemoveVersion(pkg.Name, pkg.Version)
+			},
+		})
+	}
+	return findings
+}
+
+// checkUnownedFiles looks under packages/ for directories no package
+// owns, such as those left by an uninstall that was interrupted, stray files
+// inside installed packages, and staging directories of installs that died.
+func checkUnownedFiles(packages []*InstalledPackage) []doctorFinding {
+	packagesDir := filepath.Join(getCupertinoDir(), "packages")
+
+	// installPaths holds each package's install path; leadingDirs also holds
+	// the directories above them, such as packages/@scope/name
+	owned := make(map[string]bool)
+	installPaths := make(map[string]bool)
+	leadingDirs := make(map[string]bool)
+	scriptedPaths := make(map[string]bool) // post_install scripts may add files here
+	for _, pkg := range packages {
+		installPaths[pkg.InstallPath] = true
+		if len(pkg.PostInstall) > 0 {
+			scriptedPaths[pkg.InstallPath] = true
+		}
+		for dir := pkg.InstallPath; dir != packagesDir && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			leadingDirs[dir] = true
+		}
+		for _, path := range pkg.InstalledFiles {
+			owned[path] = true
+		}
+	}
+
+	insideInstallPath := func(path string) bool {
+		for dir := path; dir != packagesDir && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if installPaths[dir] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var findings []doctorFinding
+	var stray []string
+
+	err := filepath.Walk(packagesDir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == packagesDir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && scriptedPaths[path] {
+			return filepath.SkipDir
+		}
+		if info.IsDir() && path != packagesDir && !leadingDirs[path] && !insideInstallPath(path) {
+			findings = append(findings, doctorFinding{
+				problem:    fmt.Sprintf("%s is not an installed package", path),
+				suggestion: "remove the directory",
+				fix: func() error {
+					if err := os.RemoveAll(path); err != nil {
+						return err
+					}
+					cleanupEmptyDirs(filepath.Dir(path))
+					return nil
+				},
+			})
+			return filepath.SkipDir
+		}
+
+		if !info.IsDir() && !owned[path] {
+			stray = append(stray, path)
+		}
+		return nil
+	})
+	if err != nil {
+		findings = append(findings, doctorFinding{
+			problem:    fmt.Sprintf("could not read %s: %v", packagesDir, err),
+			suggestion: "check the permissions of the install prefix",
+		})
+	}
+
+	if len(stray) > 0 {
+		findings = append(findings, doctorFinding{
+			problem:    fmt.Sprintf("%d file(s) inside installed packages are not owned by them", len(stray)),
+			details:    stray,
+			suggestion: "remove them by hand if you didn't add them on purpose; 'cupertino verify' shows other changes",
+		})
+	}
+
+	stagingRoot := filepath.Join(getCupertinoDir(), ".staging")
+	entries, _ := os.ReadDir(stagingRoot)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleStagingAge {
+			continue
+		}
+
+		path := filepath.Join(stagingRoot, entry.Name())
+		findings = append(findings, doctorFinding{
+			problem:    fmt.Sprintf("%s was left behind by an install that didn't finish", path),
+			suggestion: "remove the directory",
+			fix:        func() error { return os.RemoveAll(path) },
+		})
+	}
+
+	return findings
+}
+
+// checkBinLinks finds links in bin/ whose target is gone, and binaries of
+// active packages that aren't linked into bin/.
+func checkBinLinks(packages []*InstalledPackage) []doctorFinding {
+	binDir := getBinDir()
+	var findings []doctorFinding
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil && !os.IsNotExist(err) {
+		return []doctorFinding{{
+			problem:    fmt.Sprintf("could not read %s: %v", binDir, err),
+			suggestion: "check the permissions of the install prefix",
+		}}
+	}
+
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		link := filepath.Join(binDir, entry.Name())
+		if _, err := os.Stat(link); err == nil {
+			continue
+		}
+
+		target, _ := os.Readlink(link)
+		findings = append(findings, doctorFinding{
+			problem:    fmt.Sprintf("bin/%s points to %s, which doesn't exist", entry.Name(), target),
+			suggestion: "remove the link",
+			fix:        func() error { return os.Remove(link) },
+		})
+	}
+
+	for _, pkg := range packages {
+		if !pkg.Active {
+			continue
+		}
+
+		for _, filePath := range pkg.InstalledFiles {
+			if !strings.Contains(filePath, "/bin/") {
+				continue
+			}
+			if _, err := os.Stat(filePath); err != nil {
+				continue
+			}
+
+			link := filepath.Join(binDir, filepath.Base(filePath))
+			if _, err := os.Stat(link); err == nil {
+				continue
+			}
+
+			findings = append(findings, doctorFinding{
+				problem:    fmt.Sprintf("%s from %s v%s is not linked into bin/", filepath.Base(filePath), pkg.Name, pkg.Version),
+				suggestion: fmt.Sprintf("link it with 'cupertino switch %s %s'", pkg.Name, pkg.Version),
+				fix: func() error {
+					if err := os.MkdirAll(binDir, 0755); err != nil {
+						return err
+					}
+					os.Remove(link)
+					return os.Symlink(filePath, link)
+				},
+			})
+		}
+	}
+
+	return findings
+}
+
+func checkPath() []doctorFinding {
+	if isPathConfigured() {
+		return nil
+	}
+	return []doctorFinding{{
+		problem:    fmt.Sprintf("%s is not on your PATH", getBinDir()),
+		suggestion: fmt.Sprintf("add export PATH=\"%s:$PATH\" to your shell profile", getBinDir()),
+	}}
+}
+
+// checkRegistry calls a registry's /api/health endpoint.
+func checkRegistry(registry Registry) []doctorFinding {
+	suggestion := "check the registry URL ('cupertino config list'), your network and proxy settings"
+
+	resp, err := httpGet(registry.URL + "/api/health")
+	if err != nil {
+		return []doctorFinding{{
+			problem:    fmt.Sprintf("registry %s (%s) is unreachable: %v", registry.Name, registry.URL, err),
+			suggestion: suggestion,
+		}}
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Status string `json:"status"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+
+	if resp.StatusCode != http.StatusOK || health.Status != "ok" {
+		status := health.Status
+		if status == "" {
+			status = "unknown"
+		}
+		return []doctorFinding{{
+			problem:    fmt.Sprintf("registry %s (%s) is unhealthy: HTTP %d, status %s", registry.Name, registry.URL, resp.StatusCode, status),
+			suggestion: "try again later, or contact the registry's administrator",
+		}}
+	}
+
+	return nil
+}
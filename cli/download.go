@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const maxRetryDelay = 2 * time.Minute
+
+// httpStatusError is an unexpected response status from a download.
+type httpStatusError struct {
+	status     int
+	retryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.status)
+}
+
+// download fetches the tarball for name from url into dest. If dest holds part
+// of the file from an earlier attempt, the rest is requested with a Range
+// header. Timeouts, dropped connections, 5xx and 429 responses are retried
+// with exponential backoff.
+func download(name, url, dest string) error {
+	retries := configInt("retries")
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = downloadOnce(name, url, dest); err == nil {
+			return nil
+		}
+		if !isRetryable(err) {
+			return err
+		}
+		if attempt == retries {
+			return fmt.Errorf("%v (gave up after %d attempts)", err, attempt+1)
+		}
+
+		delay := retryDelay(attempt, err)
+		fmt.Printf("Download of %s failed (%v), retrying in %s...\n", name, err, delay.Round(time.Second))
+		time.Sleep(delay)
+	}
+}
+
+func downloadOnce(name, url, dest string) error {
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := transferClient(url).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		fmt.Printf("Resuming %s from %s\n", name, formatBytes(offset))
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the Range header, so start again
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is already complete; the checksum will tell
+		return nil
+	default:
+		return &httpStatusError{
+			status:     resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	readTimeout := configDuration("read_timeout")
+	body := &idleTimeoutReader{
+		reader:  resp.Body,
+		timeout: readTimeout,
+		timer:   time.AfterFunc(readTimeout, cancel),
+	}
+	defer body.timer.Stop()
+
+	if _, err := io.Copy(file, body); err != nil {
+		if ctx.Err() != nil {
+			return idleTimeoutError{readTimeout}
+		}
+		return err
+	}
+
+	return file.Close()
+}
+
+// idleTimeoutReader cancels a request through its timer when no data has
+// arrived for timeout.
+type idleTimeoutReader struct {
+	reader  io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.timer.Reset(r.timeout)
+	return n, err
+}
+
+// idleTimeoutError is a download that stalled for longer than read_timeout.
+// It is a net.Error so it is retried like other timeouts.
+type idleTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e idleTimeoutError) Error() string   { return fmt.Sprintf("no data received for %s", e.timeout) }
+func (e idleTimeoutError) Timeout() bool   { return true }
+func (e idleTimeoutError) Temporary() bool { return true }
+
+// isRetryable reports whether a failed download is worth trying again: a
+// server error, a rate limit, a timeout or a dropped or refused connection.
+// Anything else, such as a TLS verification failure or a bad URL, won't get
+// better by waiting.
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryDelay returns how long to wait before retry attempt+1: what the server
+// asked for in Retry-After, else 1s, 2s, 4s, ... with some jitter.
+func retryDelay(attempt int, err error) time.Duration {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+		return min(statusErr.retryAfter, maxRetryDelay)
+	}
+
+	delay := time.Second << min(attempt, 7)
+	delay += time.Duration(rand.Int63n(int64(delay) / 4))
+	return min(delay, maxRetryDelay)
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -42,7 +41,7 @@ type HomebrewBottleFile struct {
 func fetchHomebrewFormula(name string) (*HomebrewFormula, error) {
 	url := fmt.Sprintf("https://formulae.brew.sh/api/formula/%s.json", name)
 
-	resp, err := http.Get(url)
+	resp, err := httpGet(url)
 	if err != nil {
 		return nil, fmt.Errorf("fetching formula: %v", err)
 	}
@@ -114,7 +113,7 @@ func downloadBottle(url, expectedChecksum string) (string, error) {
 
 	fmt.Printf("Downloading %s...\n", url)
 
-	resp, err := http.Get(url)
+	resp, err := transferClient(url).Get(url)
 	if err != nil {
 		os.Remove(tempPath)
 		return "", fmt.Errorf("downloading bottle: %v", err)
@@ -226,10 +225,16 @@ func installFromExtractedDir(extractedDir string, pkg *Package) error {
 	}
 	defer db.Close()
 
+	checksums, err := recordFileChecksums(installedFiles)
+	if err != nil {
+		return fmt.Errorf("recording checksums: %v", err)
+	}
+
 	installedPkg := &InstalledPackage{
 		Package:        *pkg,
 		InstallPath:    packageDir,
 		InstalledFiles: installedFiles,
+		FileChecksums:  checksums,
 		InstallDate:    time.Now(),
 	}
 
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every network request goes through a client from httpClient or
+// transferClient. They honor HTTPS_PROXY, HTTP_PROXY and NO_PROXY, trust the
+// ca_bundle in addition to the system roots, present the client certificate
+// configured for the registry being contacted, send the token saved for it by
+// `cupertino login`, and identify themselves with a cupertino User-Agent.
+
+var (
+	httpClients   = make(map[string]*http.Client)
+	httpClientsMu sync.Mutex
+)
+
+// httpClient returns the client for registry API requests to rawURL. Each
+// request is limited to http_timeout.
+func httpClient(rawURL string) *http.Client {
+	return cachedHTTPClient(rawURL, configDuration("http_timeout"))
+}
+
+// transferClient returns the client for downloads and uploads, which may take
+// a long time. Only connecting and waiting for a response are limited.
+func transferClient(rawURL string) *http.Client {
+	return cachedHTTPClient(rawURL, 0)
+}
+
+func httpGet(rawURL string) (*http.Response, error) {
+	return httpClient(rawURL).Get(rawURL)
+}
+
+func cachedHTTPClient(rawURL string, timeout time.Duration) *http.Client {
+	registry, _ := registryForURL(rawURL)
+	key := fmt.Sprintf("%s/%s", registry.Name, timeout)
+
+	httpClientsMu.Lock()
+	defer httpClientsMu.Unlock()
+
+	if client, ok := httpClients[key]; ok {
+		return client
+	}
+
+	client := &http.Client{Timeout: timeout}
+	if transport, err := newTransport(registry.Name); err != nil {
+		client.Transport = failingTransport{err}
+	} else {
+		client.Transport = &registryTransport{base: transport, registryURL: registry.URL}
+	}
+
+	httpClients[key] = client
+	return client
+}
+
+func newTransport(registryName string) (*http.Transport, error) {
+	tlsConfig := &tls.Config{}
+
+	if bundle := configValue("ca_bundle"); bundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(absPath(bundle))
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", bundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if registryName != "" {
+		certs, err := parseClientCerts(configValue("client_certs"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client_certs setting: %v", err)
+		}
+		if cert, ok := certs[registryName]; ok {
+			pair, err := tls.LoadX509KeyPair(absPath(cert.certFile), absPath(cert.keyFile))
+			if err != nil {
+				return nil, fmt.Errorf("loading client certificate for %s: %v", registryName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	connectTimeout := configDuration("connect_timeout")
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: configDuration("read_timeout"),
+		MaxIdleConnsPerHost:   parallelJobs(),
+	}, nil
+}
+
+// registryForURL returns the configured registry on the same host as rawURL,
+// so downloads served by a registry use its client certificate too.
+func registryForURL(rawURL string) (Registry, bool) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return Registry{}, false
+	}
+
+	for _, registry := range getRegistries() {
+		if sameHost(registry.URL, target) {
+			return registry, true
+		}
+	}
+	return Registry{}, false
+}
+
+// sameHost reports whether target has the scheme and host of registryURL.
+func sameHost(registryURL string, target *url.URL) bool {
+	parsed, err := url.Parse(registryURL)
+	return err == nil && parsed.Scheme == target.Scheme && parsed.Host == target.Host
+}
+
+type clientCert struct {
+	certFile string
+	keyFile  string
+}
+
+// parseClientCerts parses a comma-separated list of name=cert.pem:key.pem
+// pairs. The key may be left out if the certificate file also holds it.
+func parseClientCerts(value string) (map[string]clientCert, error) {
+	certs := make(map[string]clientCert)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, files, ok := strings.Cut(entry, "=")
+		name, files = strings.TrimSpace(name), strings.TrimSpace(files)
+		if !ok || name == "" || files == "" {
+			return nil, fmt.Errorf("%q should be registry=cert.pem:key.pem", entry)
+		}
+
+		certFile, keyFile, ok := strings.Cut(files, ":")
+		if !ok {
+			keyFile = certFile
+		}
+		certs[name] = clientCert{certFile: certFile, keyFile: keyFile}
+	}
+
+	return certs, nil
+}
+
+func validateClientCerts(value string) error {
+	_, err := parseClientCerts(value)
+	return err
+}
+
+func userAgent() string {
+	return fmt.Sprintf("cupertino/%s (%s; %s)", cupertinoVersion, runtime.GOOS, runtime.GOARCH)
+}
+
+// registryTransport adds the User-Agent to every request, and the token saved
+// by `cupertino login` to requests for the registry it was saved for. Redirects
+// pass through here too, so the token is only added to requests on the
+// registry's own host: a download redirected to a blob store goes without it.
+type registryTransport struct {
+	base        http.RoundTripper
+	registryURL string
+}
+
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent())
+
+	if t.registryURL != "" && sameHost(t.registryURL, req.URL) &&
+		req.Header.Get("Authorization") == "" && req.Header.Get("X-API-Key") == "" {
+		if token := savedToken(t.registryURL); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// failingTransport fails every request with the error that prevented the
+// real transport from being built, such as an unreadable CA bundle.
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
@@ -5,68 +5,30 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"time"
 )
 
-func installFromTarball(tarballPath string) error {
-	tempDir, err := os.MkdirTemp("", "cupertino-install-*")
-	if err != nil {
-		return fmt.Errorf("creating temp dir: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	fmt.Printf("📦 Extracting to %s...\n", tempDir)
-
-	if err := extractTarGz(tarballPath, tempDir); err != nil {
-		return fmt.Errorf("extracting tarball: %v", err)
+func installFromTarball(tarballPath string, opts installOptions) error {
+	if err := checkLocalTarballPolicy(tarballPath, opts.AllowUnsigned); err != nil {
+		return err
 	}
 
-	manifestPath := filepath.Join(tempDir, "package.json")
-	pkg, err := parsePackageManifest(manifestPath)
+	tx, err := newInstallTransaction()
 	if err != nil {
-		return fmt.Errorf("parsing package.json: %v", err)
-	}
-
-	fmt.Printf("Installing %s v%s...\n", pkg.Name, pkg.Version)
-
-	packageDir := filepath.Join(getCupertinoDir(), "packages", pkg.Name, pkg.Version)
-	if err := os.MkdirAll(packageDir, 0755); err != nil {
-		return fmt.Errorf("creating package dir: %v", err)
+		return err
 	}
+	defer tx.cleanup()
+	tx.keepOtherVersions = opts.KeepOtherVersions
 
-	var installedFiles []string
-	for srcPath, destPath := range pkg.Files {
-		src := filepath.Join(tempDir, srcPath)
-		dest := filepath.Join(packageDir, destPath)
-
-		if err := copyFile(src, dest); err != nil {
-			return fmt.Errorf("copying %s: %v", srcPath, err)
-		}
-
-		installedFiles = append(installedFiles, dest)
-		fmt.Printf("Copied %s -> %s\n", srcPath, destPath)
-	}
-
-	db, err := NewSQLitePackageDB(getDatabasePath())
+	pkg, err := tx.stage(tarballPath, "")
 	if err != nil {
-		return fmt.Errorf("opening database: %v", err)
+		return err
 	}
-	defer db.Close()
 
-	installedPkg := &InstalledPackage{
-		Package:        *pkg,
-		InstallPath:    packageDir,
-		InstalledFiles: installedFiles,
-		InstallDate:    time.Now(),
+	if err := tx.commit(); err != nil {
+		return fmt.Errorf("installing %s: %v", pkg.Name, err)
 	}
-
-	if err := db.Install(installedPkg); err != nil {
-		return fmt.Errorf("updating database: %v", err)
-	}
-
-	if err := createSymlinks(installedPkg); err != nil {
-		fmt.Printf("Warning: failed to create symlinks: %v\n", err)
+	if opts.Explicit {
+		markExplicit(pkg.Name)
 	}
 
 	fmt.Printf("✅ Successfully installed %s v%s.\n", pkg.Name, pkg.Version)
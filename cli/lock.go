@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockPrefix takes an exclusive lock on <prefix>/.lock so only one process
+// changes the install tree and packages.db at a time. It waits for another
+// process holding the lock, and the lock is released by calling unlock or
+// when the process exits.
+func lockPrefix() (unlock func(), err error) {
+	return lockFile(filepath.Join(getCupertinoDir(), ".lock"), "Waiting for another cupertino process to finish...")
+}
+
+// lockFile takes an exclusive lock on path, creating it if needed, and prints
+// waitMessage if it has to wait. Locks taken through separate calls exclude
+// each other even within one process.
+func lockFile(path, waitMessage string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		fmt.Println(waitMessage)
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("locking %s: %v", path, err)
+		}
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+)
+
+const lockfileName = "cupertino.lock"
+
+const lockfileFormatVersion = 1
+
+// Lockfile records an exact set of resolved packages so an install can be
+// reproduced with `cupertino install --frozen`.
+type Lockfile struct {
+	LockfileVersion int               `json:"lockfile_version"`
+	Requested       map[string]string `json:"requested"` // package name -> constraint from the command line
+	Packages        []LockedPackage   `json:"packages"`  // in install order
+}
+
+type LockedPackage struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	Checksum     string            `json:"checksum"`
+	DownloadURL  string            `json:"download_url"`
+	Registry     string            `json:"registry,omitempty"`
+	Signature    string            `json:"signature,omitempty"`
+	SigningKey   string            `json:"signing_key,omitempty"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+func readLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock Lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	if lock.LockfileVersion > lockfileFormatVersion {
+		return nil, fmt.Errorf("%s uses lockfile version %d, this cupertino supports up to %d",
+			path, lock.LockfileVersion, lockfileFormatVersion)
+	}
+	if lock.Requested == nil {
+		lock.Requested = make(map[string]string)
+	}
+
+	return &lock, nil
+}
+
+func writeLockfile(path string, lock *Lockfile) error {
+	lock.LockfileVersion = lockfileFormatVersion
+	lock.sortPackages()
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false) // keep constraints like ">=1.0" readable
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(lock); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// updateLockfile merges a resolution into the lockfile at path, creating it
+// if needed. Packages already in the lockfile are replaced by name.
+func updateLockfile(path, name, spec string, result *ResolutionResult, source func(pkg *Package) (*RegistryPackage, error)) error {
+	lock, err := readLockfile(path)
+	if os.IsNotExist(err) {
+		lock = &Lockfile{Requested: make(map[string]string)}
+	} else if err != nil {
+		return err
+	}
+
+	lock.Requested[name] = spec
+
+	for _, pkg := range result.Packages {
+		regPkg, err := source(pkg)
+		if err != nil {
+			return fmt.Errorf("getting checksum for %s: %v", pkg.Name, err)
+		}
+
+		locked := LockedPackage{
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			Checksum:     regPkg.Checksum,
+			DownloadURL:  regPkg.DownloadURL,
+			Registry:     regPkg.Registry,
+			Signature:    regPkg.Signature,
+			SigningKey:   regPkg.SigningKey,
+			Dependencies: pkg.Dependencies,
+		}
+
+		if existing := lock.find(pkg.Name); existing != nil {
+			*existing = locked
+		} else {
+			lock.Packages = append(lock.Packages, locked)
+		}
+	}
+
+	if err := lock.validate(); err != nil {
+		fmt.Printf("Warning: %s is inconsistent and --frozen installs will fail: %v\n", path, err)
+	}
+
+	return writeLockfile(path, lock)
+}
+
+func (lock *Lockfile) find(name string) *LockedPackage {
+	for i := range lock.Packages {
+		if lock.Packages[i].Name == name {
+			return &lock.Packages[i]
+		}
+	}
+	return nil
+}
+
+// sortPackages orders packages so dependencies come first, breaking ties by
+// name so the file is stable across runs.
+func (lock *Lockfile) sortPackages() {
+	sort.Slice(lock.Packages, func(i, j int) bool {
+		return lock.Packages[i].Name < lock.Packages[j].Name
+	})
+
+	var ordered []LockedPackage
+	visited := make(map[string]bool)
+
+	var visit func(pkg *LockedPackage)
+	visit = func(pkg *LockedPackage) {
+		if visited[pkg.Name] {
+			return
+		}
+		visited[pkg.Name] = true
+
+		deps := make([]string, 0, len(pkg.Dependencies))
+		for dep := range pkg.Dependencies {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+
+		for _, dep := range deps {
+			if depPkg := lock.find(dep); depPkg != nil {
+				visit(depPkg)
+			}
+		}
+		ordered = append(ordered, *pkg)
+	}
+
+	for i := range lock.Packages {
+		visit(&lock.Packages[i])
+	}
+	lock.Packages = ordered
+}
+
+// validate checks that the locked versions satisfy every requested constraint
+// and every dependency constraint between locked packages.
+func (lock *Lockfile) validate() error {
+	names := make([]string, 0, len(lock.Requested))
+	for name := range lock.Requested {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := lock.checkLocked(name, lock.Requested[name], "requested"); err != nil {
+			return err
+		}
+	}
+
+	for _, pkg := range lock.Packages {
+		for dep, constraint := range pkg.Dependencies {
+			if err := lock.checkLocked(dep, constraint, pkg.Name+" v"+pkg.Version); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (lock *Lockfile) checkLocked(name, constraintStr, requiredBy string) error {
+	locked := lock.find(name)
+	if locked == nil {
+		return fmt.Errorf("%s is required by %s but not locked", name, requiredBy)
+	}
+
+	constraint, err := ParseConstraint(constraintStr)
+	if err != nil {
+		return err
+	}
+
+	version, err := ParseVersion(locked.Version)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	if !constraint.Satisfies(version) {
+		return fmt.Errorf("%s requires %s %s but %s is locked", requiredBy, name, constraintStr, locked.Version)
+	}
+
+	return nil
+}
+
+// closure returns the locked packages needed by names, in install order.
+func (lock *Lockfile) closure(names []string) []LockedPackage {
+	needed := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		if needed[name] {
+			return
+		}
+		needed[name] = true
+		if pkg := lock.find(name); pkg != nil {
+			for dep := range pkg.Dependencies {
+				visit(dep)
+			}
+		}
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+
+	var pkgs []LockedPackage
+	for _, pkg := range lock.Packages {
+		if needed[pkg.Name] {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
+
+// installFromLockfile installs exactly the packages recorded in the lockfile,
+// or the subset needed by specs. It fails if the lockfile no longer matches
+// the registry.
+func installFromLockfile(path string, specs []string) error {
+	lock, err := readLockfile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no %s found; run 'cupertino install <package> --lock' to create one", path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := lock.validate(); err != nil {
+		return fmt.Errorf("%s is inconsistent: %v", path, err)
+	}
+
+	selected := lock.Packages
+	var names []string // the packages asked for, as opposed to their dependencies
+	if len(specs) > 0 {
+		for _, spec := range specs {
+			name, version := parsePackageSpec(spec)
+			requested, ok := lock.Requested[name]
+			if !ok {
+				return fmt.Errorf("%s is not in %s; run 'cupertino install %s --lock' to add it", name, path, spec)
+			}
+			if version != "" && version != requested {
+				return fmt.Errorf("%s has drifted: %s was locked as %q, not %q", path, name, requested, version)
+			}
+			names = append(names, name)
+		}
+		selected = lock.closure(names)
+	} else {
+		for name := range lock.Requested {
+			names = append(names, name)
+		}
+	}
+
+	fmt.Printf("Verifying %d locked packages against the registry...\n", len(selected))
+
+	pins := loadPins()
+	for _, locked := range selected {
+		if err := checkPinned(pins, locked.Name, locked.Version); err != nil {
+			return err
+		}
+	}
+
+	err = runParallel(len(selected), func(i int) error {
+		locked := &selected[i]
+
+		info, err := lookupPackage(locked.Name)
+		if err != nil {
+			return err
+		}
+
+		// Lockfiles written before registries were recorded use the lookup's
+		if locked.Registry == "" {
+			locked.Registry = info.Registry
+		} else if info.Registry != locked.Registry {
+			return fmt.Errorf("%s now comes from %s, locked from %s", locked.Name, info.Registry, locked.Registry)
+		}
+
+		regPkg, err := getSpecificPackage(locked.Registry, locked.Name, locked.Version)
+		if err != nil {
+			return err
+		}
+		return lockDrift(locked, regPkg)
+	})
+	if err != nil {
+		return fmt.Errorf("%s has drifted:\n%v", path, err)
+	}
+
+	lockedByName := make(map[string]LockedPackage)
+	plan := &ResolutionResult{Packages: make([]*Package, 0, len(selected))}
+	for _, locked := range selected {
+		lockedByName[locked.Name] = locked
+		plan.Packages = append(plan.Packages, &Package{
+			Name:         locked.Name,
+			Version:      locked.Version,
+			Dependencies: locked.Dependencies,
+		})
+		plan.Order = append(plan.Order, locked.Name)
+	}
+
+	if outputJSON() {
+		printJSON(plan)
+	} else {
+		fmt.Printf("The following packages will be installed from %s:\n", path)
+		for _, locked := range selected {
+			fmt.Printf("  %s v%s\n", locked.Name, locked.Version)
+		}
+	}
+
+	if !confirmAction("Continue with installation?") {
+		fmt.Println("Installation cancelled.")
+		return nil
+	}
+
+	source := func(pkg *Package) (*RegistryPackage, error) {
+		locked := lockedByName[pkg.Name]
+		return &RegistryPackage{
+			Name:        locked.Name,
+			Version:     locked.Version,
+			Checksum:    locked.Checksum,
+			DownloadURL: locked.DownloadURL,
+			Registry:    locked.Registry,
+			Signature:   locked.Signature,
+			SigningKey:  locked.SigningKey,
+		}, nil
+	}
+
+	if err := installPackages(plan.Packages, source, installOptions{}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		markExplicit(name)
+	}
+
+	fmt.Printf("✅ Successfully installed %d packages from %s.\n", len(selected), path)
+	return nil
+}
+
+// lockDrift reports how the registry's copy of a locked version differs from
+// what the lockfile recorded, or nil if it doesn't.
+func lockDrift(locked *LockedPackage, regPkg *RegistryPackage) error {
+	var changes []string
+	if regPkg.Checksum != locked.Checksum {
+		changes = append(changes, fmt.Sprintf("checksum is now %s, locked %s", regPkg.Checksum, locked.Checksum))
+	}
+	if regPkg.DownloadURL != locked.DownloadURL {
+		changes = append(changes, fmt.Sprintf("download URL is now %s, locked %s", regPkg.DownloadURL, locked.DownloadURL))
+	}
+	if !maps.Equal(regPkg.Dependencies, locked.Dependencies) {
+		changes = append(changes, fmt.Sprintf("dependencies are now %v, locked %v", regPkg.Dependencies, locked.Dependencies))
+	}
+	if regPkg.Signature != locked.Signature || regPkg.SigningKey != locked.SigningKey {
+		changes = append(changes, "signature changed")
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s v%s changed in the registry: %s", locked.Name, locked.Version, strings.Join(changes, "; "))
+}
@@ -16,26 +16,60 @@ func main() {
 	}
 
 	command := args[0]
+	args = append([]string{command}, outputFlags(args[1:])...)
+	if err := validateFormat(configValue("format")); err != nil {
+		fmt.Printf("Error: invalid format: %v\n", err)
+		return
+	}
+	setupOutput()
+
 	switch command {
 	case "install":
-		if len(args) < 2 {
-			fmt.Println("Error: install requires a package name")
-			fmt.Println("Usage: cupertino install <package>")
+		var packageArgs []string
+		opts := installOptions{Explicit: true}
+		frozen := false
+		for _, arg := range args[1:] {
+			switch arg {
+			case "--lock":
+				opts.Lockfile = lockfileName
+			case "--frozen":
+				frozen = true
+			case "--keep":
+				opts.KeepOtherVersions = true
+			case "--allow-unsigned":
+				opts.AllowUnsigned = true
+			default:
+				packageArgs = append(packageArgs, arg)
+			}
+		}
+
+		if frozen {
+			if err := installFromLockfile(lockfileName, packageArgs); err != nil {
+				printError("%v", err)
+			}
 			return
 		}
 
-		packageArg := args[1]
-		if strings.HasPrefix(packageArg, ".tar.gz") || strings.Contains(packageArg, "/") {
+		if len(packageArgs) == 0 {
+			printError("install requires a package name")
+			fmt.Println("Usage: cupertino install <package> [--lock]")
+			fmt.Println("       cupertino install --frozen [package]")
+			return
+		}
+
+		packageArg := packageArgs[0]
+		// Scoped registry names like @acme/tool also contain a slash
+		if strings.HasSuffix(packageArg, ".tar.gz") || (strings.Contains(packageArg, "/") && !strings.HasPrefix(packageArg, "@")) {
 			// Local file
-			err := installFromTarball(packageArg)
+			err := installFromTarball(packageArg, opts)
 			if err != nil {
-				fmt.Printf("Error: %v\n", err)
+				printError("%v", err)
 			}
 		} else {
 			// Registry package
-			err := installFromRegistry(packageArg)
+			err := installFromRegistry(packageArg, opts)
 			if err != nil {
-				fmt.Printf("Error: %v\n", err)
+				printError("%v", err)
 			}
 		}
 	// case "brew":
@@ -53,21 +87,21 @@ func main() {
 	// 	}
 	case "uninstall":
 		if len(args) < 2 {
-			fmt.Println("Error: uninstall requires a package name")
+			printError("uninstall requires a package name")
 			fmt.Println("Usage: cupertino uninstall <package>")
 			return
 		}
 		uninstall(args[1:])
 	case "search":
 		if len(args) < 2 {
-			fmt.Println("Error: search requires a query")
+			printError("search requires a query")
 			fmt.Println("Usage: cupertino search <query>")
 			return
 		}
 		search(args[1])
 	case "info":
 		if len(args) < 2 {
-			fmt.Println("Error: info requires a package name")
+			printError("info requires a package name")
 			fmt.Println("Usage: cupertino info <package>")
 			return
 		}
@@ -78,6 +112,71 @@ func main() {
 		} else {
 			upgrade(args[1])
 		}
+	case "why":
+		if len(args) < 2 {
+			printError("why requires a package name")
+			fmt.Println("Usage: cupertino why <package>")
+			return
+		}
+		why(args[1])
+	case "deps":
+		deps(args[1:])
+	case "outdated":
+		outdated()
+	case "switch":
+		if len(args) < 2 {
+			printError("switch requires a package name and version")
+			fmt.Println("Usage: cupertino switch <package> <version>")
+			return
+		}
+		switchPackage(args[1:])
+	case "rollback":
+		if len(args) < 2 {
+			printError("rollback requires a package name")
+			fmt.Println("Usage: cupertino rollback <package>")
+			return
+		}
+		rollback(args[1])
+	case "history":
+		if len(args) < 2 {
+			showHistory("")
+		} else {
+			showHistory(args[1])
+		}
+	case "pin":
+		if len(args) < 2 {
+			printError("pin requires a package name")
+			fmt.Println("Usage: cupertino pin <package>[@constraint]")
+			return
+		}
+		pinPackage(args[1:])
+	case "unpin":
+		if len(args) < 2 {
+			printError("unpin requires a package name")
+			fmt.Println("Usage: cupertino unpin <package>")
+			return
+		}
+		unpinPackage(args[1])
+	case "bundle":
+		bundle(args[1:])
+	case "config":
+		configCommand(args[1:])
+	case "verify":
+		verify(args[1:])
+	case "doctor":
+		doctor(args[1:])
+	case "cache":
+		cacheCommand(args[1:])
+	case "login":
+		login(args[1:])
+	case "logout":
+		logout(args[1:])
+	case "whoami":
+		whoami()
+	case "keygen":
+		keygen(args[1:])
+	case "trust":
+		trustCommand(args[1:])
 	case "publish":
 		publish(args[1:])
 	case "init":
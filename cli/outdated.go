@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// outdatedPackage is a row of `cupertino outdated`.
+type outdatedPackage struct {
+	Name    string `json:"name"`
+	Current string `json:"current"`
+	// Wanted is the newest version the package's pin and the constraints of
+	// the packages that depend on it allow. It is never older than Current:
+	// it is "" if they conflict or only allow older versions.
+	Wanted      string   `json:"wanted"`
+	Latest      string   `json:"latest"`
+	Constraints []string `json:"constraints,omitempty"` // e.g. "app requires ^1.2", "pinned to ~1.4"
+	Registry    string   `json:"registry,omitempty"`
+	// Error is why the package couldn't be checked. Such packages are only
+	// listed in JSON output; the text output prints a warning instead.
+	Error string `json:"error,omitempty"`
+}
+
+// outdated lists installed packages with newer versions in the registry
+// without changing anything. It exits 1 if any are outdated, and 2 if some
+// packages couldn't be checked.
+func outdated() {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		outdatedFailed("opening database: %v", err)
+	}
+	defer db.Close()
+
+	packages, err := db.List()
+	if err != nil {
+		outdatedFailed("listing packages: %v", err)
+	}
+
+	pins, err := db.Pins()
+	if err != nil {
+		outdatedFailed("reading pins: %v", err)
+	}
+
+	infos := make([]*RegistryPackageInfo, len(packages))
+	lookupErrs := make([]error, len(packages))
+	checkErr := runParallel(len(packages), func(i int) error {
+		info, err := lookupPackage(packages[i].Name)
+		if err != nil {
+			lookupErrs[i] = err
+			return fmt.Errorf("  %s: %v", packages[i].Name, err)
+		}
+		infos[i] = info
+		return nil
+	})
+	if checkErr != nil && !outputJSON() {
+		fmt.Fprintf(os.Stderr, "Warning: could not check some packages:\n%v\n", checkErr)
+	}
+
+	results := []outdatedPackage{}
+	var unchecked []outdatedPackage
+	for i, pkg := range packages {
+		if infos[i] == nil {
+			unchecked = append(unchecked, outdatedPackage{Name: pkg.Name, Current: pkg.Version, Error: lookupErrs[i].Error()})
+			continue
+		}
+
+		result, err := checkOutdated(db, pkg, infos[i], pins[pkg.Name])
+		if err != nil {
+			if !outputJSON() {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			unchecked = append(unchecked, outdatedPackage{Name: pkg.Name, Current: pkg.Version, Error: err.Error()})
+			continue
+		}
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	if outputJSON() {
+		printJSON(append(results, unchecked...))
+	} else {
+		printOutdated(results, len(unchecked) == 0)
+	}
+
+	switch {
+	case len(results) > 0:
+		os.Exit(1)
+	case len(unchecked) > 0:
+		os.Exit(2)
+	}
+}
+
+// outdatedFailed reports an error that stops outdated from checking anything,
+// as {"error": "..."} in JSON mode, and exits 2.
+func outdatedFailed(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if outputJSON() {
+		printJSON(jsonError{Error: message})
+	} else {
+		fmt.Fprintf(os.Stderr, "Error %s\n", message)
+	}
+	os.Exit(2)
+}
+
+// checkOutdated compares an installed package with the registry, returning
+// nil if it is on the latest version.
+func checkOutdated(db *SQLitePackageDB, pkg *InstalledPackage, info *RegistryPackageInfo, pin string) (*outdatedPackage, error) {
+	if !isNewerVersion(info.Latest, pkg.Version) {
+		return nil, nil
+	}
+
+	result := &outdatedPackage{
+		Name:     pkg.Name,
+		Current:  pkg.Version,
+		Latest:   info.Latest,
+		Registry: info.Registry,
+	}
+
+	var constraints []VersionConstraint
+	if pin != "" {
+		constraint, err := ParseConstraint(pin)
+		if err != nil {
+			return nil, fmt.Errorf("%s has an invalid pin %q: %v", pkg.Name, pin, err)
+		}
+		constraints = append(constraints, constraint)
+		result.Constraints = append(result.Constraints, "pinned to "+pin)
+	}
+
+	dependents, err := db.GetDependents(pkg.Name)
+	if err != nil {
+		return nil, fmt.Errorf("reading dependents of %s: %v", pkg.Name, err)
+	}
+	for _, dependent := range dependents {
+		required := dependent.Dependencies[pkg.Name]
+		if required == "" {
+			continue
+		}
+		constraint, err := ParseConstraint(required)
+		if err != nil {
+			continue // an unparseable constraint was never enforced at install either
+		}
+		constraints = append(constraints, constraint)
+		result.Constraints = append(result.Constraints, fmt.Sprintf("%s requires %s", dependent.Name, required))
+	}
+
+	if len(constraints) == 0 {
+		result.Wanted = info.Latest
+		return result, nil
+	}
+
+	for _, versionStr := range sortVersionsDesc(info.Versions) {
+		if isNewerVersion(pkg.Version, versionStr) {
+			break // outdated never suggests a downgrade
+		}
+		version, _ := ParseVersion(versionStr)
+		allowed := true
+		for _, constraint := range constraints {
+			if !constraint.Satisfies(version) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			result.Wanted = versionStr
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// isNewerVersion reports whether candidate is a newer version than current.
+// Versions that don't parse are compared for equality only.
+func isNewerVersion(candidate, current string) bool {
+	candidateVersion, err1 := ParseVersion(candidate)
+	currentVersion, err2 := ParseVersion(current)
+	if err1 != nil || err2 != nil {
+		return candidate != "" && candidate != current
+	}
+	return candidateVersion.Compare(currentVersion) > 0
+}
+
+func printOutdated(results []outdatedPackage, checkedAll bool) {
+	switch {
+	case len(results) == 0 && checkedAll:
+		fmt.Println("All packages are up to date")
+		return
+	case len(results) == 0:
+		fmt.Println("The packages that could be checked are up to date")
+		return
+	}
+
+	fmt.Printf("%-20s %-10s %-10s %s\n", "Package", "Current", "Wanted", "Latest")
+	for _, result := range results {
+		wanted := result.Wanted
+		if wanted == "" {
+			wanted = "-"
+		}
+
+		line := fmt.Sprintf("%-20s %-10s %-10s %-10s", result.Name, result.Current, wanted, result.Latest)
+		if len(result.Constraints) > 0 {
+			line += " (" + strings.Join(result.Constraints, ", ") + ")"
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// In JSON mode (--json, or the format setting set to json), list, info,
+// search, outdated and the install plan print a single JSON document on
+// stdout. Progress messages and prompts still print, but to stderr, so the
+// output can be piped straight into a parser. The documents are described in
+// docs/json-output.md; fields are only ever added, not renamed or removed.
+
+var jsonFlag = flag.Bool("json", false, "Print JSON instead of text (same as --format json)")
+var formatFlag = flag.String("format", "", "Output format: table or json")
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// jsonStdout is the real standard output once setupOutput has pointed
+// os.Stdout at stderr.
+var jsonStdout = os.Stdout
+
+func validateFormat(value string) error {
+	if value != formatTable && value != formatJSON {
+		return fmt.Errorf("%q should be table or json", value)
+	}
+	return nil
+}
+
+func outputJSON() bool {
+	return *jsonFlag || configValue("format") == formatJSON
+}
+
+// outputFlags removes --json and --format from a command's arguments, so they
+// work after the command name as well as before it.
+func outputFlags(args []string) []string {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--json":
+			flag.Set("json", "true")
+		case args[i] == "--format" && i+1 < len(args):
+			flag.Set("format", args[i+1])
+			i++
+		case strings.HasPrefix(args[i], "--format="):
+			flag.Set("format", strings.TrimPrefix(args[i], "--format="))
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return rest
+}
+
+// setupOutput sends everything printed with fmt.Print* to stderr in JSON
+// mode, leaving stdout to printJSON.
+func setupOutput() {
+	if outputJSON() {
+		jsonStdout = os.Stdout
+		os.Stdout = os.Stderr
+	}
+}
+
+func printJSON(v any) {
+	encoder := json.NewEncoder(jsonStdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// jsonError is how a failed command reports its error in JSON mode.
+type jsonError struct {
+	Error string `json:"error"`
+}
+
+// printError reports why a command failed: as {"error": "..."} followed by
+// exit status 1 in JSON mode, otherwise as an "Error: ..." line.
+func printError(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if outputJSON() {
+		printJSON(jsonError{Error: message})
+		os.Exit(1)
+	}
+	fmt.Printf("Error: %s\n", message)
+}
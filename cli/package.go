@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"os"
+	"time"
+)
 
 type Package struct {
 	Name        string `json:"name"`
@@ -26,4 +29,29 @@ type InstalledPackage struct {
 	InstallPath    string    `json:"install_path"`
 	InstalledFiles []string  `json:"installed_files"`
 	InstallDate    time.Time `json:"install_date"`
+	Active         bool      `json:"active"`   // linked into bin/
+	Retained       bool      `json:"retained"` // replaced by an upgrade, kept for rollback
+	Registry       string    `json:"registry"` // URL of the registry it came from, "" for local tarballs
+	Explicit       bool      `json:"explicit"` // asked for by the user rather than only needed by another package
+
+	// Contents of each installed file when it was installed, keyed by path.
+	// Packages installed before checksums were recorded have none.
+	FileChecksums map[string]FileChecksum `json:"file_checksums,omitempty"`
+}
+
+// FileChecksum records an installed file so `cupertino verify` can tell if it
+// has changed since.
+type FileChecksum struct {
+	SHA256 string      `json:"sha256"`
+	Size   int64       `json:"size"`
+	Mode   os.FileMode `json:"mode"` // permission bits
+}
+
+// HistoryEntry records one change of a package's active version.
+type HistoryEntry struct {
+	Name        string    `json:"name"`
+	FromVersion string    `json:"from_version"`
+	ToVersion   string    `json:"to_version"`
+	Action      string    `json:"action"`
+	Date        time.Time `json:"date"`
 }
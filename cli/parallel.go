@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// parallelJobs returns how many registry requests or downloads may run at
+// once.
+func parallelJobs() int {
+	return max(configInt("jobs"), 1)
+}
+
+// runParallel calls fn for every index in [0, n) on a bounded pool of
+// goroutines and waits for all of them. It does not stop at the first
+// failure; every error is returned, joined in index order.
+func runParallel(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	slots := make(chan struct{}, parallelJobs())
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = fn(i)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
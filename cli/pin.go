@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func pinPackage(args []string) {
+	name, constraint := parsePackageSpec(args[0])
+	if constraint == "" && len(args) > 1 {
+		constraint = strings.Join(args[1:], " ")
+	}
+
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	if !db.HasAnyVersion(name) {
+		fmt.Printf("Package '%s' is not installed\n", name)
+		return
+	}
+
+	installedVersion, err := db.GetInstalledVersion(name)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// Without a constraint, hold the package at the installed version
+	if constraint == "" {
+		constraint = installedVersion
+	}
+
+	parsed, err := ParseConstraint(constraint)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if err := db.Pin(name, constraint); err != nil {
+		fmt.Printf("Error pinning %s: %v\n", name, err)
+		return
+	}
+
+	fmt.Printf("📌 Pinned %s to %s\n", name, constraint)
+
+	if version, err := ParseVersion(installedVersion); err == nil && !parsed.Satisfies(version) {
+		fmt.Printf("Warning: installed v%s does not satisfy the pin; run 'cupertino upgrade %s' to move it into range\n",
+			installedVersion, name)
+	}
+}
+
+func unpinPackage(name string) {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	removed, err := db.Unpin(name)
+	if err != nil {
+		fmt.Printf("Error unpinning %s: %v\n", name, err)
+		return
+	}
+	if !removed {
+		fmt.Printf("%s is not pinned\n", name)
+		return
+	}
+
+	fmt.Printf("Unpinned %s\n", name)
+}
+
+// loadPins returns every pinned package, or an empty map if the database
+// cannot be read.
+func loadPins() map[string]string {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		return map[string]string{}
+	}
+	defer db.Close()
+
+	pins, err := db.Pins()
+	if err != nil {
+		return map[string]string{}
+	}
+	return pins
+}
+
+// checkPinned returns an error if installing version of name would move it
+// outside its pin.
+func checkPinned(pins map[string]string, name, version string) error {
+	pin, ok := pins[name]
+	if !ok {
+		return nil
+	}
+
+	constraint, err := ParseConstraint(pin)
+	if err != nil {
+		return fmt.Errorf("%s has an invalid pin %q: %v", name, pin, err)
+	}
+
+	parsed, err := ParseVersion(version)
+	if err != nil || !constraint.Satisfies(parsed) {
+		return fmt.Errorf("%s is pinned to %s; run 'cupertino unpin %s' to install v%s", name, pin, name, version)
+	}
+
+	return nil
+}
+
+// upgradeTarget returns the version a package should be upgraded to: the
+// registry's latest, or the newest version within the pin if it has one.
+func upgradeTarget(info *RegistryPackageInfo, pin string) (string, error) {
+	if pin == "" {
+		return info.Latest, nil
+	}
+
+	constraint, err := ParseConstraint(pin)
+	if err != nil {
+		return "", fmt.Errorf("%s has an invalid pin %q: %v", info.Name, pin, err)
+	}
+
+	for _, versionStr := range sortVersionsDesc(info.Versions) {
+		version, err := ParseVersion(versionStr)
+		if err == nil && constraint.Satisfies(version) {
+			return versionStr, nil
+		}
+	}
+
+	return "", fmt.Errorf("no version of %s satisfies its pin %s", info.Name, pin)
+}
@@ -23,7 +23,7 @@ func initPackage() {
 		return
 	}
 
-	fmt.Println("Creating a new cupertino package\n")
+	fmt.Print("Creating a new cupertino package\n\n")
 
 	name := prompt(reader, "name", filepath.Base(cwd()))
 	version := prompt(reader, "version", "1.0.0")
@@ -75,10 +75,13 @@ func initPackage() {
 }
 
 func publish(args []string) {
-	dryRun := false
+	dryRun, sign := false, false
 	for _, arg := range args {
-		if arg == "--dry-run" {
+		switch arg {
+		case "--dry-run":
 			dryRun = true
+		case "--sign":
+			sign = true
 		}
 	}
 
@@ -106,6 +109,13 @@ func publish(args []string) {
 		return
 	}
 
+	for depName, constraint := range pkg.Dependencies {
+		if _, err := ParseConstraint(constraint); err != nil {
+			fmt.Printf("Error: dependency '%s': %v\n", depName, err)
+			return
+		}
+	}
+
 	// Check that all files exist
 	for srcPath := range pkg.Files {
 		if _, err := os.Stat(srcPath); os.IsNotExist(err) {
@@ -127,7 +137,7 @@ func publish(args []string) {
 	}
 
 	// Build tarball
-	tarballName := fmt.Sprintf("%s-%s.tar.gz", pkg.Name, pkg.Version)
+	tarballName := fmt.Sprintf("%s-%s.tar.gz", strings.NewReplacer("@", "", "/", "-").Replace(pkg.Name), pkg.Version)
 	fmt.Printf("\nBuilding %s...\n", tarballName)
 
 	// Collect all files to include
@@ -143,11 +153,29 @@ func publish(args []string) {
 	}
 	defer os.Remove(tarballName)
 
+	var signature *packageSignature
+	if sign {
+		sig, publicKey, err := signTarball(&pkg, tarballName)
+		if err != nil {
+			fmt.Printf("Error signing package: %v\n", err)
+			return
+		}
+		signature = &packageSignature{Signature: sig, SigningKey: publicKey}
+		fmt.Printf("Signed with key %s\n", keyID(publicKey))
+	}
+
+	registry, err := publishRegistry(pkg.Name)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
 	// Get API key
-	apiKey := os.Getenv("CUPERTINO_API_KEY")
+	apiKey := registryToken(registry.URL)
 	if apiKey == "" {
-		fmt.Println("Error: CUPERTINO_API_KEY environment variable is required")
-		fmt.Println("Set it with: export CUPERTINO_API_KEY=your-key")
+		fmt.Printf("Error: not logged in to %s\n", registry.URL)
+		fmt.Printf("Log in with: cupertino login --registry %s\n", registry.Name)
+		fmt.Println("         or: export CUPERTINO_API_KEY=your-key")
 		return
 	}
 
@@ -157,10 +185,9 @@ func publish(args []string) {
 	}
 
 	// Upload
-	registryURL := getRegistryURL()
-	fmt.Printf("Publishing to %s...\n", registryURL)
+	fmt.Printf("Publishing to %s...\n", registry.URL)
 
-	if err := uploadPackage(registryURL, apiKey, tarballName, &pkg); err != nil {
+	if err := uploadPackage(registry.URL, apiKey, tarballName, &pkg, signature); err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
@@ -168,7 +195,13 @@ func publish(args []string) {
 	fmt.Printf("Published %s v%s\n", pkg.Name, pkg.Version)
 }
 
-func uploadPackage(registryURL, apiKey, tarballPath string, pkg *Package) error {
+// packageSignature is uploaded with the metadata of a signed package.
+type packageSignature struct {
+	Signature  string
+	SigningKey string
+}
+
+func uploadPackage(registryURL, apiKey, tarballPath string, pkg *Package, signature *packageSignature) error {
 	tarball, err := os.Open(tarballPath)
 	if err != nil {
 		return fmt.Errorf("opening tarball: %v", err)
@@ -190,6 +223,10 @@ func uploadPackage(registryURL, apiKey, tarballPath string, pkg *Package) error
 	if len(pkg.Dependencies) > 0 {
 		metadata["dependencies"] = pkg.Dependencies
 	}
+	if signature != nil {
+		metadata["signature"] = signature.Signature
+		metadata["signing_key"] = signature.SigningKey
+	}
 
 	metadataJSON, err := json.Marshal(metadata)
 	if err != nil {
@@ -220,7 +257,7 @@ func uploadPackage(registryURL, apiKey, tarballPath string, pkg *Package) error
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("X-API-Key", apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := transferClient(registryURL).Do(req)
 	if err != nil {
 		return fmt.Errorf("uploading: %v", err)
 	}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+const defaultRegistryName = "default"
+
+var errPackageNotFound = errors.New("not found")
+
+// Registry is a named package source. The "registries" setting lists extra
+// registries in the order they are searched; the "registry" setting is always
+// searched last under the name "default".
+type Registry struct {
+	Name string
+	URL  string
+}
+
+// registryRoute sends every package whose name matches pattern to one
+// registry, e.g. "@acme/*=acme".
+type registryRoute struct {
+	pattern  string
+	registry string
+}
+
+// parseRegistries parses a comma-separated list of name=url pairs.
+func parseRegistries(value string) ([]Registry, error) {
+	var registries []Registry
+	seen := map[string]bool{defaultRegistryName: true}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, registryURL, ok := strings.Cut(entry, "=")
+		name, registryURL = strings.TrimSpace(name), strings.TrimSpace(registryURL)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q should be name=url", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("registry %q is listed twice", name)
+		}
+		if err := validateURL(registryURL); err != nil {
+			return nil, err
+		}
+		seen[name] = true
+
+		registries = append(registries, Registry{Name: name, URL: strings.TrimSuffix(registryURL, "/")})
+	}
+
+	return registries, nil
+}
+
+// parseRegistryRoutes parses a comma-separated list of pattern=registry pairs.
+func parseRegistryRoutes(value string) ([]registryRoute, error) {
+	var routes []registryRoute
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, registry, ok := strings.Cut(entry, "=")
+		pattern, registry = strings.TrimSpace(pattern), strings.TrimSpace(registry)
+		if !ok || pattern == "" || registry == "" {
+			return nil, fmt.Errorf("%q should be pattern=registry", entry)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %v", pattern, err)
+		}
+
+		routes = append(routes, registryRoute{pattern: pattern, registry: registry})
+	}
+
+	return routes, nil
+}
+
+func validateRegistries(value string) error {
+	_, err := parseRegistries(value)
+	return err
+}
+
+func validateRegistryRoutes(value string) error {
+	_, err := parseRegistryRoutes(value)
+	return err
+}
+
+// getRegistries returns every configured registry in search order.
+func getRegistries() []Registry {
+	registries, err := parseRegistries(configValue("registries"))
+	if err != nil {
+		fmt.Printf("Warning: ignoring invalid registries setting: %v\n", err)
+		registries = nil
+	}
+	return append(registries, Registry{Name: defaultRegistryName, URL: getRegistryURL()})
+}
+
+func findRegistry(name string) (Registry, bool) {
+	for _, registry := range getRegistries() {
+		if registry.Name == name {
+			return registry, true
+		}
+	}
+	return Registry{}, false
+}
+
+// registryName returns the configured name for a registry URL, or the URL
+// itself if it is no longer configured.
+func registryName(registryURL string) string {
+	for _, registry := range getRegistries() {
+		if registry.URL == registryURL {
+			return registry.Name
+		}
+	}
+	return registryURL
+}
+
+// routedRegistry returns the registry a routing rule sends name to, if any.
+func routedRegistry(name string) (Registry, bool, error) {
+	routes, err := parseRegistryRoutes(configValue("routes"))
+	if err != nil {
+		return Registry{}, false, fmt.Errorf("invalid routes setting: %v", err)
+	}
+
+	for _, route := range routes {
+		if matched, _ := path.Match(route.pattern, name); !matched {
+			continue
+		}
+		registry, ok := findRegistry(route.registry)
+		if !ok {
+			return Registry{}, false, fmt.Errorf("route %s=%s names an unknown registry", route.pattern, route.registry)
+		}
+		return registry, true, nil
+	}
+
+	return Registry{}, false, nil
+}
+
+// registriesFor returns the registries to search for a package: the one a
+// route sends it to, else the one it was installed from, else all of them.
+func registriesFor(name string) ([]Registry, error) {
+	if registry, ok, err := routedRegistry(name); err != nil || ok {
+		return []Registry{registry}, err
+	}
+
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err == nil {
+		registryURL := db.GetRegistry(name)
+		db.Close()
+		if registryURL != "" {
+			return []Registry{{Name: registryName(registryURL), URL: registryURL}}, nil
+		}
+	}
+
+	return getRegistries(), nil
+}
+
+// publishRegistry returns the registry a package should be published to.
+func publishRegistry(name string) (Registry, error) {
+	if registry, ok, err := routedRegistry(name); err != nil || ok {
+		return registry, err
+	}
+	return Registry{Name: defaultRegistryName, URL: getRegistryURL()}, nil
+}
+
+var (
+	packageInfoCache   = make(map[string]*RegistryPackageInfo)
+	packageInfoCacheMu sync.Mutex
+)
+
+// lookupPackage finds a package in the first registry that has it and
+// remembers the answer for the rest of the run. The returned info's Registry
+// records where it was found.
+func lookupPackage(name string) (*RegistryPackageInfo, error) {
+	packageInfoCacheMu.Lock()
+	info, ok := packageInfoCache[name]
+	packageInfoCacheMu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	info, err := findPackage(name)
+	if err != nil {
+		return nil, err
+	}
+
+	packageInfoCacheMu.Lock()
+	packageInfoCache[name] = info
+	packageInfoCacheMu.Unlock()
+	return info, nil
+}
+
+// prefetchPackages looks up names concurrently so later lookupPackage calls
+// are answered from memory. Failures are left for those calls to report.
+func prefetchPackages(names []string) {
+	runParallel(len(names), func(i int) error {
+		_, err := lookupPackage(names[i])
+		return err
+	})
+}
+
+// findPackage searches the registries for name, or the download cache in
+// --offline mode.
+func findPackage(name string) (*RegistryPackageInfo, error) {
+	if isOffline() {
+		return cachedPackageInfo(name)
+	}
+
+	registries, err := registriesFor(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, registry := range registries {
+		info, err := getPackageInfo(registry.URL, name)
+		if errors.Is(err, errPackageNotFound) {
+			continue
+		}
+		if err != nil {
+			if len(registries) > 1 {
+				return nil, fmt.Errorf("%s registry: %v", registry.Name, err)
+			}
+			return nil, err
+		}
+
+		return info, nil
+	}
+
+	if len(registries) == 1 {
+		return nil, fmt.Errorf("package '%s' not found", name)
+	}
+
+	names := make([]string, len(registries))
+	for i, registry := range registries {
+		names[i] = registry.Name
+	}
+	return nil, fmt.Errorf("package '%s' not found in %s", name, strings.Join(names, ", "))
+}
+
+// packageURL builds a registry API URL for a package, escaping scoped names
+// such as "@acme/tool".
+func packageURL(registryURL, name string) string {
+	return registryURL + "/api/packages/" + url.PathEscape(name)
+}
+
+func packageVersionURL(registryURL, name, version string) string {
+	return packageURL(registryURL, name) + "/" + url.PathEscape(version)
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"strings"
+	"sync"
 )
 
 const defaultRegistry = "https://cupertino.sh"
@@ -23,6 +22,10 @@ type RegistryPackage struct {
 	Checksum     string            `json:"checksum"`
 	Size         int64             `json:"size"`
 	DownloadURL  string            `json:"download_url"`
+	Signature    string            `json:"signature,omitempty"`   // base64 ed25519 signature, see signing.go
+	SigningKey   string            `json:"signing_key,omitempty"` // base64 public key that made it
+
+	Registry string `json:"registry,omitempty"` // URL of the registry it was fetched from
 }
 
 type RegistryPackageInfo struct {
@@ -33,45 +36,51 @@ type RegistryPackageInfo struct {
 	Versions    []string `json:"versions"`
 	Latest      string   `json:"latest"`
 	Downloads   int      `json:"downloads"`
+
+	Registry string `json:"registry,omitempty"` // URL of the registry it was fetched from
+}
+
+type installOptions struct {
+	Lockfile          string // if set, record the resolved packages in this lockfile
+	KeepOtherVersions bool   // install next to existing versions instead of replacing them
+	Explicit          bool   // the user asked for this package, see `cupertino why`
+	AllowUnsigned     bool   // install a local tarball even if signature_policy is enforce
 }
 
-func installFromRegistry(packageSpec string) error {
+// markExplicit records that the user asked for name, even if it was already
+// installed as a dependency.
+func markExplicit(name string) {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err == nil {
+		defer db.Close()
+		err = db.SetExplicit(name)
+	}
+	if err != nil {
+		fmt.Printf("Warning: could not record that %s was installed explicitly: %v\n", name, err)
+	}
+}
+
+func installFromRegistry(packageSpec string, opts installOptions) error {
 	name, version := parsePackageSpec(packageSpec)
-	registryURL := getRegistryURL()
+	pins := loadPins()
 
 	fmt.Printf("Fetching package info for %s...\n", name)
 
-	var rootPkg *Package
-	var err error
+	// A pinned package with no requested version resolves within its pin
+	spec := version
+	if spec == "" {
+		spec = pins[name]
+	}
 
-	if version == "" {
-		regPkg, err := getLatestPackage(registryURL, name)
-		if err != nil {
-			return fmt.Errorf("failed to get package info: %v", err)
-		}
-		rootPkg = &Package{
-			Name:         regPkg.Name,
-			Version:      regPkg.Version,
-			Description:  regPkg.Description,
-			Homepage:     regPkg.Homepage,
-			License:      regPkg.License,
-			Dependencies: regPkg.Dependencies,
-			Files:        regPkg.Files,
-		}
-	} else {
-		regPkg, err := getSpecificPackage(registryURL, name, version)
-		if err != nil {
-			return fmt.Errorf("failed to get package info: %v", err)
-		}
-		rootPkg = &Package{
-			Name:         regPkg.Name,
-			Version:      regPkg.Version,
-			Description:  regPkg.Description,
-			Homepage:     regPkg.Homepage,
-			License:      regPkg.License,
-			Dependencies: regPkg.Dependencies,
-			Files:        regPkg.Files,
-		}
+	regPkg, err := resolvePackageSpec(name, spec)
+	if err != nil {
+		return fmt.Errorf("failed to get package info: %v", err)
+	}
+
+	rootPkg := regPkg.toPackage()
+
+	if err := checkPinned(pins, rootPkg.Name, rootPkg.Version); err != nil {
+		return err
 	}
 
 	fmt.Printf("Building dependency tree for %s v%s...\n", rootPkg.Name, rootPkg.Version)
@@ -81,14 +90,18 @@ func installFromRegistry(packageSpec string) error {
 		return fmt.Errorf("dependency resolution failed: %v", err)
 	}
 
-	fmt.Printf("Found %d packages to install:\n", len(result.Packages))
-	for _, pkg := range result.Packages {
-		fmt.Printf("  %s v%s\n", pkg.Name, pkg.Version)
-	}
+	if outputJSON() {
+		printJSON(result)
+	} else {
+		fmt.Printf("Found %d packages to install:\n", len(result.Packages))
+		for _, pkg := range result.Packages {
+			fmt.Printf("  %s v%s\n", pkg.Name, pkg.Version)
+		}
 
-	fmt.Printf("The following packages will be installed:\n")
-	for _, pkg := range result.Packages {
-		fmt.Printf("  %s v%s\n", pkg.Name, pkg.Version)
+		fmt.Printf("The following packages will be installed:\n")
+		for _, pkg := range result.Packages {
+			fmt.Printf("  %s v%s\n", pkg.Name, pkg.Version)
+		}
 	}
 
 	if !confirmAction("Continue with installation?") {
@@ -96,7 +109,72 @@ func installFromRegistry(packageSpec string) error {
 		return nil
 	}
 
-	for _, pkg := range result.Packages {
+	var sourcesMu sync.Mutex
+	sources := make(map[string]*RegistryPackage)
+	source := func(pkg *Package) (*RegistryPackage, error) {
+		sourcesMu.Lock()
+		regPkg, ok := sources[pkg.Name]
+		sourcesMu.Unlock()
+		if ok {
+			return regPkg, nil
+		}
+		info, err := lookupPackage(pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		regPkg, err = getSpecificPackage(info.Registry, pkg.Name, pkg.Version)
+		if err != nil {
+			return nil, err
+		}
+		sourcesMu.Lock()
+		sources[pkg.Name] = regPkg
+		sourcesMu.Unlock()
+		return regPkg, nil
+	}
+
+	if err := installPackages(result.Packages, source, opts); err != nil {
+		return err
+	}
+	if opts.Explicit {
+		markExplicit(rootPkg.Name)
+	}
+
+	if opts.Lockfile != "" {
+		if err := updateLockfile(opts.Lockfile, name, version, result, source); err != nil {
+			return fmt.Errorf("writing %s: %v", opts.Lockfile, err)
+		}
+		fmt.Printf("Wrote %s\n", opts.Lockfile)
+	}
+
+	fmt.Printf("✅ Successfully installed %s v%s.\n", rootPkg.Name, rootPkg.Version)
+	return nil
+}
+
+// installPackages downloads, stages and commits pkgs in order as a single
+// transaction, skipping any that are already installed. source supplies the
+// download URL and checksum for each package. Downloads run in parallel, but
+// packages are staged in the order given.
+func installPackages(pkgs []*Package, source func(pkg *Package) (*RegistryPackage, error), opts installOptions) error {
+	tx, err := newInstallTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.cleanup()
+	tx.keepOtherVersions = opts.KeepOtherVersions
+
+	type plannedPackage struct {
+		activate bool   // already installed side by side; just switch to it
+		tarball  string // downloaded tarball to stage
+		registry string
+	}
+	plan := make([]plannedPackage, len(pkgs))
+	var downloads []int
+
+	for i, pkg := range pkgs {
+		if isInactiveVersion(pkg.Name, pkg.Version) {
+			plan[i].activate = true
+			continue
+		}
 
 		shouldInstall, reason, err := evaluateInstallationNeed(pkg.Name, pkg.Version)
 		if err != nil {
@@ -108,82 +186,132 @@ func installFromRegistry(packageSpec string) error {
 			continue
 		}
 
-		fmt.Printf("Installing %s v%s...\n", pkg.Name, pkg.Version)
+		fmt.Printf("Downloading %s v%s (%s)...\n", pkg.Name, pkg.Version, reason)
+		downloads = append(downloads, i)
+	}
 
-		// If replacing existing version, remove it first
-		if strings.Contains(reason, "upgrade") || strings.Contains(reason, "downgrade") || strings.Contains(reason, "replace") {
-			fmt.Printf("Removing previous version of %s...\n", pkg.Name)
-			if err := removePackageByName(pkg.Name); err != nil {
-				fmt.Printf("Warning: failed to remove old version: %v\n", err)
-			}
-		}
+	trusted, err := loadTrustStore()
+	if err != nil {
+		return fmt.Errorf("reading trusted keys: %v", err)
+	}
 
-		regPkg, err := getSpecificPackage(registryURL, pkg.Name, pkg.Version)
+	err = runParallel(len(downloads), func(j int) error {
+		i := downloads[j]
+		pkg := pkgs[i]
+
+		regPkg, err := source(pkg)
 		if err != nil {
 			return fmt.Errorf("failed to get download info for %s: %v", pkg.Name, err)
 		}
 
-		tempFile, err := downloadAndVerify(regPkg.DownloadURL, regPkg.Checksum)
+		tarballPath, err := downloadAndVerify(regPkg)
 		if err != nil {
 			return fmt.Errorf("failed to download %s: %v", pkg.Name, err)
 		}
-		defer os.Remove(tempFile)
 
-		if err := installFromTarball(tempFile); err != nil {
-			return fmt.Errorf("failed to install %s: %v", pkg.Name, err)
+		if err := verifyPackageSignature(regPkg, trusted); err != nil {
+			return err
 		}
+
+		plan[i].tarball, plan[i].registry = tarballPath, regPkg.Registry
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, pkg := range pkgs {
+		switch {
+		case plan[i].activate:
+			fmt.Printf("Switching to installed %s v%s\n", pkg.Name, pkg.Version)
+			tx.activate(pkg.Name, pkg.Version)
+		case plan[i].tarball != "":
+			if _, err := tx.stage(plan[i].tarball, plan[i].registry); err != nil {
+				return fmt.Errorf("failed to stage %s: %v", pkg.Name, err)
+			}
+		}
+	}
+
+	if err := tx.commit(); err != nil {
+		var rbErr *incompleteRollbackError
+		if errors.As(err, &rbErr) {
+			return fmt.Errorf("installation failed and some changes could not be undone, run 'cupertino doctor': %v", err)
+		}
+		return fmt.Errorf("installation failed, no changes were made: %v", err)
 	}
 
-	fmt.Printf("✅ Successfully installed %s v%s.\n", rootPkg.Name, rootPkg.Version)
 	return nil
 }
 
+func (regPkg *RegistryPackage) toPackage() *Package {
+	return &Package{
+		Name:         regPkg.Name,
+		Version:      regPkg.Version,
+		Description:  regPkg.Description,
+		Homepage:     regPkg.Homepage,
+		License:      regPkg.License,
+		Dependencies: regPkg.Dependencies,
+		Files:        regPkg.Files,
+	}
+}
+
+// parsePackageSpec splits "name@version". A leading "@" is part of a scoped
+// name such as "@acme/tool@1.2".
 func parsePackageSpec(spec string) (name, version string) {
-	if strings.Contains(spec, "@") {
-		parts := strings.SplitN(spec, "@", 2)
-		return parts[0], parts[1]
+	if i := strings.LastIndex(spec, "@"); i > 0 {
+		return spec[:i], spec[i+1:]
 	}
 
 	return spec, ""
 }
 
 func getRegistryURL() string {
-	if url := os.Getenv("CUPERTINO_REGISTRY"); url != "" {
-		return url
-	}
-
-	return defaultRegistry
+	return strings.TrimSuffix(configValue("registry"), "/")
 }
 
-func getLatestPackage(registryURL, name string) (*RegistryPackage, error) {
-	url := fmt.Sprintf("%s/api/packages/%s", registryURL, name)
-
-	resp, err := http.Get(url)
+// resolvePackageSpec finds the registry package for name, where spec is empty
+// (latest), an exact version, or a constraint such as "^1.2 || ^2.0".
+func resolvePackageSpec(name, spec string) (*RegistryPackage, error) {
+	info, err := lookupPackage(name)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	registryURL := info.Registry
 
-	if resp.StatusCode == 404 {
-		return nil, fmt.Errorf("package '%s' not found", name)
+	if spec == "" {
+		return getSpecificPackage(registryURL, name, info.Latest)
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("registry error: HTTP %d", resp.StatusCode)
+	exact := strings.TrimPrefix(spec, "=")
+	for _, version := range info.Versions {
+		if version == exact {
+			return getSpecificPackage(registryURL, name, version)
+		}
 	}
 
-	var info RegistryPackageInfo
-	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
-		return nil, fmt.Errorf("failed to parse package info: %v", err)
+	constraint, err := ParseConstraint(spec)
+	if err != nil {
+		return nil, err
 	}
 
-	return getSpecificPackage(registryURL, name, info.Latest)
+	for _, version := range sortVersionsDesc(info.Versions) {
+		parsed, _ := ParseVersion(version)
+		if constraint.Satisfies(parsed) {
+			return getSpecificPackage(registryURL, name, version)
+		}
+	}
+
+	return nil, fmt.Errorf("no version of %s satisfies %s", name, spec)
 }
 
 func getSpecificPackage(registryURL, name, version string) (*RegistryPackage, error) {
-	url := fmt.Sprintf("%s/api/packages/%s/%s", registryURL, name, version)
+	if isOffline() {
+		return cachedPackage(name, version)
+	}
+
+	url := packageVersionURL(registryURL, name, version)
 
-	resp, err := http.Get(url)
+	resp, err := httpGet(url)
 	if err != nil {
 		return nil, err
 	}
@@ -201,46 +329,91 @@ func getSpecificPackage(registryURL, name, version string) (*RegistryPackage, er
 	if err := json.NewDecoder(resp.Body).Decode(&pkg); err != nil {
 		return nil, fmt.Errorf("failed to parse package: %v", err)
 	}
+	pkg.Registry = registryURL
 
 	return &pkg, nil
 }
 
-func downloadAndVerify(url, expectedChecksum string) (string, error) {
-	resp, err := http.Get(url)
+// downloadAndVerify returns the path of the verified tarball for regPkg in the
+// download cache, downloading it first if it isn't cached.
+func downloadAndVerify(regPkg *RegistryPackage) (string, error) {
+	if regPkg.Checksum != "" && !validChecksum(regPkg.Checksum) {
+		return "", fmt.Errorf("the registry sent an invalid checksum %q", regPkg.Checksum)
+	}
+
+	if path, ok := useCachedTarball(regPkg); ok {
+		return path, nil
+	}
+
+	if isOffline() {
+		return "", fmt.Errorf("%s v%s is not in the download cache (offline)", regPkg.Name, regPkg.Version)
+	}
+
+	if regPkg.Checksum == "" {
+		return "", fmt.Errorf("the registry did not provide a checksum")
+	}
+
+	// Download next to the cache so the verified file can be renamed into
+	// place. The partial file is kept on failure so the next attempt resumes.
+	if err := os.MkdirAll(getCacheDir(), 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	// Other processes and workers fetching the same tarball share the partial
+	// file, so only one downloads it at a time
+	unlock, err := lockFile(cachedTarballPath(regPkg.Checksum)+".lock",
+		fmt.Sprintf("Waiting for another download of %s v%s...", regPkg.Name, regPkg.Version))
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
+	defer unlock()
 
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
+	if path, ok := useCachedTarball(regPkg); ok {
+		return path, nil
 	}
+	return fetchTarball(regPkg)
+}
 
-	tempFile, err := os.CreateTemp("", "cupertino-download-*.tar.gz")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %v", err)
+func useCachedTarball(regPkg *RegistryPackage) (string, bool) {
+	path, ok := cachedTarball(regPkg.Checksum)
+	if !ok {
+		return "", false
 	}
 
-	tempPath := tempFile.Name()
+	fmt.Printf("Using cached %s v%s\n", regPkg.Name, regPkg.Version)
+	if _, err := os.Stat(cacheMetadataPath(regPkg.Checksum)); os.IsNotExist(err) {
+		writeCacheMetadata(regPkg)
+	}
+	return path, true
+}
 
-	hasher := sha256.New()
-	writer := io.MultiWriter(tempFile, hasher)
+// fetchTarball downloads regPkg into the cache. The caller holds the lock on
+// its partial file.
+func fetchTarball(regPkg *RegistryPackage) (string, error) {
+	partialPath := cachedTarballPath(regPkg.Checksum) + ".partial"
+	_, statErr := os.Stat(partialPath)
+	resumed := statErr == nil
 
-	_, err = io.Copy(writer, resp.Body)
-	tempFile.Close()
+	if err := download(regPkg.Name, regPkg.DownloadURL, partialPath); err != nil {
+		return "", fmt.Errorf("download failed: %v", err)
+	}
 
+	actualChecksum, err := fileChecksum(partialPath)
 	if err != nil {
-		os.Remove(tempPath)
-		return "", fmt.Errorf("download failed: %v", err)
+		return "", err
 	}
+	if actualChecksum != regPkg.Checksum {
+		os.Remove(partialPath)
 
-	actualChecksum := fmt.Sprintf("%x", hasher.Sum(nil))
-	if actualChecksum != expectedChecksum {
-		os.Remove(tempPath)
-		return "", fmt.Errorf("checksum mismatch: expected %s, got %s", expectedChecksum, actualChecksum)
+		// A stale partial file can spoil a resumed download, so try once more from scratch
+		if resumed {
+			fmt.Printf("Checksum mismatch after resuming %s, downloading it again\n", regPkg.Name)
+			return fetchTarball(regPkg)
+		}
+		return "", fmt.Errorf("checksum mismatch: expected %s, got %s", regPkg.Checksum, actualChecksum)
 	}
 
-	return tempPath, nil
+	return addToCache(partialPath, regPkg)
 }
 
 func formatBytes(bytes int64) string {
@@ -260,16 +433,16 @@ func formatBytes(bytes int64) string {
 }
 
 func getPackageInfo(registryURL, packageName string) (*RegistryPackageInfo, error) {
-	url := fmt.Sprintf("%s/api/packages/%s", registryURL, packageName)
+	url := packageURL(registryURL, packageName)
 
-	resp, err := http.Get(url)
+	resp, err := httpGet(url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == 404 {
-		return nil, fmt.Errorf("package '%s' not found", packageName)
+		return nil, fmt.Errorf("package '%s' %w", packageName, errPackageNotFound)
 	}
 
 	if resp.StatusCode != 200 {
@@ -280,10 +453,28 @@ func getPackageInfo(registryURL, packageName string) (*RegistryPackageInfo, erro
 	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
 		return nil, fmt.Errorf("failed to parse package info: %v", err)
 	}
+	info.Registry = registryURL
 
 	return &info, nil
 }
 
+// isInactiveVersion reports whether version is installed side by side but
+// is not the active version.
+func isInactiveVersion(name, version string) bool {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+
+	if !db.IsInstalledVersion(name, version) {
+		return false
+	}
+
+	active, err := db.GetInstalledVersion(name)
+	return err == nil && active != version
+}
+
 func evaluateInstallationNeed(name, targetVersion string) (bool, string, error) {
 	db, err := NewSQLitePackageDB(getDatabasePath())
 	if err != nil {
@@ -320,68 +511,3 @@ func evaluateInstallationNeed(name, targetVersion string) (bool, string, error)
 
 	return false, "same version already installed", nil
 }
-
-func satisfiesDependencyConstraint(packageName, constraint string) (bool, error) {
-	db, err := NewSQLitePackageDB(getDatabasePath())
-	if err != nil {
-		return false, err
-	}
-	defer db.Close()
-
-	if !db.HasAnyVersion(packageName) {
-		return false, nil
-	}
-
-	installedVersion, err := db.GetInstalledVersion(packageName)
-	if err != nil {
-		return false, err
-	}
-
-	parsedConstraint, err := ParseConstraint(constraint)
-	if err != nil {
-		return false, err
-	}
-
-	installedVer, err := ParseVersion(installedVersion)
-	if err != nil {
-		return false, err
-	}
-
-	return parsedConstraint.Satisfies(installedVer), nil
-}
-
-func removePackageByName(name string) error {
-	db, err := NewSQLitePackageDB(getDatabasePath())
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	if !db.HasAnyVersion(name) {
-		return fmt.Errorf("package %s is not installed", name)
-	}
-
-	pkg, err := db.Get(name)
-	if err != nil {
-		return err
-	}
-
-	removeSymlinks(pkg)
-
-	filesRemoved := 0
-	for _, filePath := range pkg.InstalledFiles {
-		if err := os.Remove(filePath); err == nil {
-			filesRemoved++
-		}
-	}
-
-	cleanupEmptyDirs(pkg.InstallPath)
-
-	err = db.Remove(name)
-	if err != nil {
-		return err
-	}
-
-	fmt.Printf("Removed %s (%d files)\n", name, filesRemoved)
-	return nil
-}
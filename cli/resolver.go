@@ -2,127 +2,332 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type ResolutionResult struct {
-	Packages []*Package
-	Order    []string // Package names in install order
+	Packages []*Package `json:"packages"`
+	Order    []string   `json:"order"` // Package names in install order
+}
+
+// requirement is a single constraint on a package name, along with the chain
+// of packages that led to it so conflicts can be explained.
+type requirement struct {
+	name       string
+	constraint string
+	chain      []string // e.g. ["app v1.0.0", "libbar v2.1.0"]; empty for a pin
+}
+
+func (req requirement) String() string {
+	if len(req.chain) == 0 {
+		return fmt.Sprintf("%s is pinned to %s", req.name, req.constraint)
+	}
+	return fmt.Sprintf("%s requires %s %s", strings.Join(req.chain, " -> "), req.name, req.constraint)
+}
+
+// ResolutionConflict is returned when no version of a package satisfies every
+// constraint placed on it.
+type ResolutionConflict struct {
+	Name         string
+	Requirements []requirement
+	Available    []string
+}
+
+func (c *ResolutionConflict) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "no version of %s satisfies all requirements:\n", c.Name)
+	for _, req := range c.Requirements {
+		fmt.Fprintf(&b, "  %s\n", req)
+	}
+	if len(c.Available) > 0 {
+		fmt.Fprintf(&b, "available versions: %s", strings.Join(c.Available, ", "))
+	} else {
+		fmt.Fprintf(&b, "no versions of %s are available", c.Name)
+	}
+	return b.String()
+}
+
+type resolver struct {
+	installed map[string]*InstalledPackage
+	pins      map[string]string
+	versions  map[string][]string
+	packages  map[string]*Package
 }
 
 func ResolveDependencies(rootPackage *Package) (*ResolutionResult, error) {
-	resolved := make([]*Package, 0)
-	visited := make(map[string]bool)
-	visiting := make(map[string]bool)
-	order := make([]string, 0)
+	r := &resolver{
+		installed: make(map[string]*InstalledPackage),
+		pins:      make(map[string]string),
+		versions:  make(map[string][]string),
+		packages:  make(map[string]*Package),
+	}
 
-	err := resolveDepsRecursive(rootPackage, &resolved, &order, visited, visiting)
-	if err != nil {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err == nil {
+		if installed, err := db.List(); err == nil {
+			for _, pkg := range installed {
+				r.installed[pkg.Name] = pkg
+			}
+		}
+		if pins, err := db.Pins(); err == nil {
+			r.pins = pins
+		}
+		db.Close()
+	}
+
+	selected := map[string]*Package{rootPackage.Name: rootPackage}
+	reqs := dependencyRequirements(rootPackage, nil)
+	r.prefetch(rootPackage)
+
+	if err := r.solve(selected, reqs, 0); err != nil {
 		return nil, err
 	}
 
-	return &ResolutionResult{
-		Packages: resolved,
-		Order:    order,
-	}, nil
+	return orderResolution(rootPackage, selected)
 }
 
-func resolveDepsRecursive(
-	pkg *Package,
-	resolved *[]*Package,
-	order *[]string,
-	visited,
-	visiting map[string]bool,
-) error {
-	pkgKey := pkg.Name + "@" + pkg.Version
+func dependencyRequirements(pkg *Package, parentChain []string) []requirement {
+	chain := append(append([]string{}, parentChain...), fmt.Sprintf("%s v%s", pkg.Name, pkg.Version))
 
-	// Check circular dependency
-	if visiting[pkgKey] {
-		return fmt.Errorf("circular dependency detected: %s", pkg.Name)
+	names := make([]string, 0, len(pkg.Dependencies))
+	for name := range pkg.Dependencies {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	reqs := make([]requirement, 0, len(names))
+	for _, name := range names {
+		reqs = append(reqs, requirement{name: name, constraint: pkg.Dependencies[name], chain: chain})
+	}
+	return reqs
+}
 
-	// Already processed
-	if visited[pkgKey] {
+// solve walks the requirement list in order, choosing a version for each new
+// name and backtracking to the next candidate when a later requirement
+// cannot be met.
+func (r *resolver) solve(selected map[string]*Package, reqs []requirement, next int) error {
+	if next == len(reqs) {
 		return nil
 	}
 
-	visiting[pkgKey] = true
+	req := reqs[next]
+	related := requirementsFor(req.name, reqs[:next+1])
+	if pin, ok := r.pins[req.name]; ok {
+		related = append(related, requirement{name: req.name, constraint: pin})
+	}
 
-	for depName, constraintStr := range pkg.Dependencies {
-		depPkg, err := fetchPackage(depName, constraintStr)
+	if pkg, ok := selected[req.name]; ok {
+		ok, err := satisfiesAll(pkg.Version, related)
 		if err != nil {
-			return fmt.Errorf("resolving dependency %s: %v", depName, err)
+			return err
 		}
+		if !ok {
+			return r.conflict(req.name, related)
+		}
+		return r.solve(selected, reqs, next+1)
+	}
 
-		err = resolveDepsRecursive(depPkg, resolved, order, visited, visiting)
+	candidates, err := r.candidates(req.name, related)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return r.conflict(req.name, related)
+	}
+
+	var firstErr error
+	for _, candidate := range candidates {
+		pkg, err := r.fetch(req.name, candidate)
 		if err != nil {
 			return err
 		}
+
+		selected[req.name] = pkg
+		extended := append(append([]requirement{}, reqs...), dependencyRequirements(pkg, req.chain)...)
+
+		err = r.solve(selected, extended, next+1)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*ResolutionConflict); !ok {
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		delete(selected, req.name)
 	}
 
-	visiting[pkgKey] = false
-	visited[pkgKey] = true
-	*resolved = append(*resolved, pkg)
-	*order = append(*order, pkg.Name)
+	return firstErr
+}
 
-	return nil
+func requirementsFor(name string, reqs []requirement) []requirement {
+	var related []requirement
+	for _, req := range reqs {
+		if req.name == name {
+			related = append(related, req)
+		}
+	}
+	return related
 }
 
-func fetchPackage(name, constraintStr string) (*Package, error) {
-	satisfied, err := satisfiesDependencyConstraint(name, constraintStr)
-	if err == nil && satisfied {
-		db, err := NewSQLitePackageDB(getDatabasePath())
-		if err == nil {
-			defer db.Close()
-			if installedPkg, err := db.Get(name); err == nil {
-				return &installedPkg.Package, nil
-			}
+func satisfiesAll(versionStr string, reqs []requirement) (bool, error) {
+	version, err := ParseVersion(versionStr)
+	if err != nil {
+		return false, nil
+	}
+
+	for _, req := range reqs {
+		constraint, err := ParseConstraint(req.constraint)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", req, err)
+		}
+		if !constraint.Satisfies(version) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// candidates returns every version of name that satisfies reqs, newest first.
+// An installed version that satisfies reqs is always tried first.
+func (r *resolver) candidates(name string, reqs []requirement) ([]string, error) {
+	var candidates []string
+
+	if installed, ok := r.installed[name]; ok {
+		ok, err := satisfiesAll(installed.Version, reqs)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			candidates = append(candidates, installed.Version)
 		}
 	}
 
-	registryURL := getRegistryURL()
-	constraint, err := ParseConstraint(constraintStr)
+	available, err := r.availableVersions(name)
 	if err != nil {
 		return nil, err
 	}
 
-	packageInfo, err := getPackageInfo(registryURL, name)
+	for _, versionStr := range available {
+		if len(candidates) > 0 && versionStr == candidates[0] {
+			continue
+		}
+		ok, err := satisfiesAll(versionStr, reqs)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			candidates = append(candidates, versionStr)
+		}
+	}
+
+	return candidates, nil
+}
+
+// availableVersions returns the registry's valid versions of name, newest first.
+func (r *resolver) availableVersions(name string) ([]string, error) {
+	if versions, ok := r.versions[name]; ok {
+		return versions, nil
+	}
+
+	packageInfo, err := lookupPackage(name)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("resolving dependency %s: %v", name, err)
+	}
+
+	versions := sortVersionsDesc(packageInfo.Versions)
+	r.versions[name] = versions
+	return versions, nil
+}
+
+func (r *resolver) fetch(name, version string) (*Package, error) {
+	if installed, ok := r.installed[name]; ok && installed.Version == version {
+		return &installed.Package, nil
 	}
 
-	var bestVersion string
-	var bestVersionParsed Version
+	key := name + "@" + version
+	if pkg, ok := r.packages[key]; ok {
+		return pkg, nil
+	}
 
-	for _, versionStr := range packageInfo.Versions {
-		version, err := ParseVersion(versionStr)
-		if err != nil {
-			continue // Skip invalid versions
+	info, err := lookupPackage(name)
+	if err != nil {
+		return nil, fmt.Errorf("resolving dependency %s: %v", name, err)
+	}
+
+	regPkg, err := getSpecificPackage(info.Registry, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("resolving dependency %s: %v", name, err)
+	}
+
+	pkg := regPkg.toPackage()
+	r.packages[key] = pkg
+	r.prefetch(pkg)
+	return pkg, nil
+}
+
+// prefetch looks up pkg's dependencies concurrently before the solver needs
+// them. Installed dependencies are skipped, since they usually need no lookup.
+func (r *resolver) prefetch(pkg *Package) {
+	var names []string
+	for name := range pkg.Dependencies {
+		if _, ok := r.installed[name]; !ok {
+			names = append(names, name)
 		}
+	}
+	prefetchPackages(names)
+}
+
+func (r *resolver) conflict(name string, reqs []requirement) error {
+	available, _ := r.availableVersions(name)
+	return &ResolutionConflict{
+		Name:         name,
+		Requirements: reqs,
+		Available:    available,
+	}
+}
 
-		if constraint.Satisfies(version) {
-			if bestVersion == "" || version.Compare(bestVersionParsed) > 0 {
-				bestVersion = versionStr
-				bestVersionParsed = version
+// orderResolution sorts the selected packages so every package comes after
+// its dependencies.
+func orderResolution(root *Package, selected map[string]*Package) (*ResolutionResult, error) {
+	result := &ResolutionResult{}
+	visited := make(map[string]bool)
+	visiting := make(map[string]bool)
+
+	var visit func(pkg *Package) error
+	visit = func(pkg *Package) error {
+		if visiting[pkg.Name] {
+			return fmt.Errorf("circular dependency detected: %s", pkg.Name)
+		}
+		if visited[pkg.Name] {
+			return nil
+		}
+
+		visiting[pkg.Name] = true
+
+		names := make([]string, 0, len(pkg.Dependencies))
+		for name := range pkg.Dependencies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := visit(selected[name]); err != nil {
+				return err
 			}
 		}
-	}
 
-	if bestVersion == "" {
-		return nil, fmt.Errorf("no version of %s satisfies constraint %s", name, constraintStr)
+		visiting[pkg.Name] = false
+		visited[pkg.Name] = true
+		result.Packages = append(result.Packages, pkg)
+		result.Order = append(result.Order, pkg.Name)
+		return nil
 	}
 
-	regPkg, err := getSpecificPackage(registryURL, name, bestVersion)
-	if err != nil {
+	if err := visit(root); err != nil {
 		return nil, err
 	}
-
-	return &Package{
-		Name:         regPkg.Name,
-		Version:      regPkg.Version,
-		Description:  regPkg.Description,
-		Homepage:     regPkg.Homepage,
-		License:      regPkg.License,
-		Dependencies: regPkg.Dependencies,
-		Files:        regPkg.Files,
-	}, nil
+	return result, nil
 }
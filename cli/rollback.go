@@ -0,0 +1,114 @@
+package main
+
+import "fmt"
+
+// rollback re-activates the version a package was on before its last
+// upgrade. The previous version's files are kept on disk, so no download is
+// needed.
+func rollback(name string) {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	if !db.HasAnyVersion(name) {
+		fmt.Printf("Package '%s' is not installed\n", name)
+		return
+	}
+
+	current, err := db.Get(name)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	previous, err := db.PreviousVersion(name, current.Version)
+	if err != nil {
+		fmt.Printf("Error reading history: %v\n", err)
+		return
+	}
+
+	// Fall back to the retained version if the recorded one has been removed
+	if previous == "" || !db.IsInstalledVersion(name, previous) {
+		previous = ""
+		versions, err := db.ListVersions(name)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		for _, version := range versions {
+			if version.Retained {
+				previous = version.Version
+				break
+			}
+		}
+	}
+
+	if previous == "" {
+		fmt.Printf("No previous version of %s is installed to roll back to\n", name)
+		return
+	}
+
+	pins, err := db.Pins()
+	if err != nil {
+		fmt.Printf("Error reading pins: %v\n", err)
+		return
+	}
+	if err := checkPinned(pins, name, previous); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("%s: %s -> %s\n", name, current.Version, previous)
+
+	if !confirmAction("Roll back?") {
+		fmt.Println("Rollback cancelled.")
+		return
+	}
+
+	if err := switchActiveVersion(db, name, previous); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// Keep the version we rolled back from so the rollback can be undone
+	if err := db.SetRetained(name, current.Version, true); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if err := db.RecordHistory(name, current.Version, previous, "rollback"); err != nil {
+		fmt.Printf("Warning: could not record history: %v\n", err)
+	}
+
+	fmt.Printf("✅ Rolled back %s to v%s\n", name, previous)
+}
+
+func showHistory(name string) {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	history, err := db.History(name)
+	if err != nil {
+		fmt.Printf("Error reading history: %v\n", err)
+		return
+	}
+
+	if len(history) == 0 {
+		fmt.Println("No history recorded")
+		return
+	}
+
+	for _, entry := range history {
+		from := entry.FromVersion
+		if from == "" {
+			from = "-"
+		}
+		fmt.Printf("  %s  %-20s %-10s %s -> %s\n",
+			entry.Date.Format("2006-01-02 15:04"), entry.Name, entry.Action, from, entry.ToVersion)
+	}
+}
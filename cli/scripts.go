@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const (
+	hookPreInstall  = "pre_install"
+	hookPostInstall = "post_install"
+	hookPreRemove   = "pre_remove"
+	hookPostRemove  = "post_remove"
+)
+
+var lifecycleHooks = []string{hookPreInstall, hookPostInstall, hookPreRemove, hookPostRemove}
+
+// Environment variables passed through from the caller to lifecycle scripts.
+// Everything else is dropped so scripts run the same way on every machine.
+var scriptPassthroughEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "LANG", "TERM"}
+
+func getScripts(pkg *Package, hook string) []string {
+	switch hook {
+	case hookPreInstall:
+		return pkg.PreInstall
+	case hookPostInstall:
+		return pkg.PostInstall
+	case hookPreRemove:
+		return pkg.PreRemove
+	case hookPostRemove:
+		return pkg.PostRemove
+	}
+
+	return nil
+}
+
+func setScripts(pkg *Package, hook string, scripts []string) {
+	switch hook {
+	case hookPreInstall:
+		pkg.PreInstall = scripts
+	case hookPostInstall:
+		pkg.PostInstall = scripts
+	case hookPreRemove:
+		pkg.PreRemove = scripts
+	case hookPostRemove:
+		pkg.PostRemove = scripts
+	}
+}
+
+func runLifecycleScripts(pkg *Package, hook, installDir string) error {
+	scripts := getScripts(pkg, hook)
+	if len(scripts) == 0 {
+		return nil
+	}
+
+	workDir := installDir
+	if _, err := os.Stat(workDir); err != nil {
+		workDir = getCupertinoDir()
+	}
+
+	env := scriptEnvironment(pkg, hook, installDir)
+
+	for i, script := range scripts {
+		fmt.Printf("Running %s script (%d/%d) for %s...\n", hook, i+1, len(scripts), pkg.Name)
+
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Dir = workDir
+		cmd.Env = env
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s script %q failed: %v", hook, script, err)
+		}
+	}
+
+	return nil
+}
+
+func scriptEnvironment(pkg *Package, hook, installDir string) []string {
+	var env []string
+	for _, key := range scriptPassthroughEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	return append(env,
+		"CUPERTINO_PREFIX="+getCupertinoDir(),
+		"CUPERTINO_BIN_DIR="+getBinDir(),
+		"CUPERTINO_PACKAGE_NAME="+pkg.Name,
+		"CUPERTINO_PACKAGE_VERSION="+pkg.Version,
+		"CUPERTINO_INSTALL_DIR="+installDir,
+		"CUPERTINO_HOOK="+hook,
+	)
+}
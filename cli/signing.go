@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Publishers sign a package with an ed25519 key over signedMessage, which
+// binds the signature to the package name and version as well as the tarball
+// checksum. The signature and public key are uploaded with the metadata and
+// returned by the registry as RegistryPackage.Signature and SigningKey.
+//
+// Installs check them against the trust store, a list of public keys kept in
+// the prefix. The signature_policy setting decides how strict this is:
+// "verify" refuses packages whose signature doesn't verify and warns about
+// untrusted keys, "enforce" also refuses unsigned packages and untrusted keys,
+// and "off" skips the check.
+
+const (
+	signaturePolicyOff     = "off"
+	signaturePolicyVerify  = "verify"
+	signaturePolicyEnforce = "enforce"
+)
+
+// trustedKey is a public key in the trust store.
+type trustedKey struct {
+	Key     string    `json:"key"` // base64 ed25519 public key
+	Name    string    `json:"name,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+func signedMessage(name, version, checksum string) []byte {
+	return []byte(fmt.Sprintf("cupertino-signature-v1\n%s\n%s\n%s", name, version, checksum))
+}
+
+// keyID returns a short fingerprint of a base64 public key.
+func keyID(publicKey string) string {
+	sum := sha256.Sum256([]byte(publicKey))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+func parsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("not a base64 ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func validateSignaturePolicy(value string) error {
+	switch value {
+	case signaturePolicyOff, signaturePolicyVerify, signaturePolicyEnforce:
+		return nil
+	}
+	return fmt.Errorf("%q should be off, verify or enforce", value)
+}
+
+func defaultSigningKeyPath() string {
+	if config := userConfigPath(); config != "" {
+		return filepath.Join(filepath.Dir(config), "signing.key")
+	}
+	return ""
+}
+
+// keygen creates the ed25519 key pair used by `publish --sign`.
+func keygen(args []string) {
+	force := false
+	for _, arg := range args {
+		if arg == "--force" {
+			force = true
+		}
+	}
+
+	path := absPath(configValue("signing_key"))
+	if path == "" {
+		fmt.Println("Error: could not determine where to store the signing key")
+		return
+	}
+	if _, err := os.Stat(path); err == nil && !force {
+		fmt.Printf("Error: %s already exists (use --force to replace it)\n", path)
+		return
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Printf("Error generating key: %v\n", err)
+		return
+	}
+	encodedPublic := base64.StdEncoding.EncodeToString(publicKey)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(privateKey)+"\n"), 0600); err != nil {
+		fmt.Printf("Error writing %s: %v\n", path, err)
+		return
+	}
+	if err := os.WriteFile(path+".pub", []byte(encodedPublic+"\n"), 0644); err != nil {
+		fmt.Printf("Error writing %s.pub: %v\n", path, err)
+		return
+	}
+
+	fmt.Printf("🔑 Wrote %s and %s.pub\n", path, path)
+	fmt.Printf("Key ID: %s\n", keyID(encodedPublic))
+	fmt.Printf("Public key: %s\n", encodedPublic)
+	fmt.Println("Share the public key so users can run: cupertino trust add <public key>")
+}
+
+// loadSigningKey reads the private key written by keygen.
+func loadSigningKey() (ed25519.PrivateKey, error) {
+	path := absPath(configValue("signing_key"))
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no signing key at %s; run 'cupertino keygen' first", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%s is not an ed25519 signing key", path)
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// signTarball returns the base64 signature and public key for a package.
+func signTarball(pkg *Package, tarballPath string) (signature, publicKey string, err error) {
+	privateKey, err := loadSigningKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	checksum, err := fileChecksum(tarballPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	sig := ed25519.Sign(privateKey, signedMessage(pkg.Name, pkg.Version, checksum))
+	public := privateKey.Public().(ed25519.PublicKey)
+	return base64.StdEncoding.EncodeToString(sig), base64.StdEncoding.EncodeToString(public), nil
+}
+
+func trustStorePath() string {
+	return filepath.Join(getCupertinoDir(), "trusted-keys.json")
+}
+
+func loadTrustStore() (map[string]trustedKey, error) {
+	keys := make(map[string]trustedKey)
+
+	data, err := os.ReadFile(trustStorePath())
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", trustStorePath(), err)
+	}
+	return keys, nil
+}
+
+func saveTrustStore(keys map[string]trustedKey) error {
+	if err := os.MkdirAll(getCupertinoDir(), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(trustStorePath(), append(data, '\n'), 0644)
+}
+
+// checkLocalTarballPolicy applies the signature policy to a local tarball,
+// which has no signature: enforce refuses it unless allowUnsigned is set, and
+// verify warns.
+func checkLocalTarballPolicy(path string, allowUnsigned bool) error {
+	switch configValue("signature_policy") {
+	case signaturePolicyEnforce:
+		if !allowUnsigned {
+			return fmt.Errorf("%s is not signed (signature_policy is enforce); pass --allow-unsigned to install it anyway", path)
+		}
+		fmt.Printf("Warning: installing unsigned %s (--allow-unsigned)\n", path)
+	case signaturePolicyVerify:
+		fmt.Printf("Warning: %s is a local tarball and is not signed\n", path)
+	}
+	return nil
+}
+
+// verifyPackageSignature checks a downloaded package against the trust store
+// and the signature policy. It returns an error if the package must not be
+// installed, and prints a warning if it may be installed but isn't trusted.
+func verifyPackageSignature(regPkg *RegistryPackage, trusted map[string]trustedKey) error {
+	policy := configValue("signature_policy")
+	if policy == signaturePolicyOff {
+		return nil
+	}
+
+	untrusted := func(reason string) error {
+		if policy == signaturePolicyEnforce {
+			return fmt.Errorf("%s v%s %s (signature_policy is enforce)", regPkg.Name, regPkg.Version, reason)
+		}
+		fmt.Printf("Warning: %s v%s %s\n", regPkg.Name, regPkg.Version, reason)
+		return nil
+	}
+
+	if regPkg.Signature == "" {
+		if policy == signaturePolicyEnforce {
+			return untrusted("is not signed")
+		}
+		return nil
+	}
+
+	publicKey, err := parsePublicKey(regPkg.SigningKey)
+	if err != nil {
+		return fmt.Errorf("%s v%s has an invalid signing key: %v", regPkg.Name, regPkg.Version, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(regPkg.Signature)
+	if err != nil || !ed25519.Verify(publicKey, signedMessage(regPkg.Name, regPkg.Version, regPkg.Checksum), signature) {
+		return fmt.Errorf("%s v%s has a signature that does not match its contents", regPkg.Name, regPkg.Version)
+	}
+
+	id := keyID(regPkg.SigningKey)
+	if key, ok := trusted[id]; !ok || key.Key != regPkg.SigningKey {
+		return untrusted(fmt.Sprintf("is signed by untrusted key %s", id))
+	}
+
+	return nil
+}
+
+func trustCommand(args []string) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		trustList()
+	case "add":
+		if len(args) < 2 {
+			fmt.Println("Usage: cupertino trust add <public key | file> [--name <name>]")
+			return
+		}
+		name := ""
+		for i := 2; i < len(args); i++ {
+			if args[i] == "--name" && i+1 < len(args) {
+				name = args[i+1]
+				i++
+			}
+		}
+		trustAdd(args[1], name)
+	case "remove":
+		if len(args) < 2 {
+			fmt.Println("Usage: cupertino trust remove <key id>")
+			return
+		}
+		trustRemove(args[1])
+	default:
+		fmt.Printf("Unknown trust command: %s\n", args[0])
+		fmt.Println("Usage: cupertino trust [list|add|remove]")
+	}
+}
+
+func trustList() {
+	keys, err := loadTrustStore()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Signature policy: %s\n", configValue("signature_policy"))
+	if len(keys) == 0 {
+		fmt.Println("No trusted keys")
+		return
+	}
+
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		key := keys[id]
+		fmt.Printf("  %s  %-20s %s (added %s)\n", id, key.Name, key.Key, key.AddedAt.Format("2006-01-02"))
+	}
+}
+
+// trustAdd adds a public key, given directly or as a .pub file.
+func trustAdd(keyOrFile, name string) {
+	encoded := keyOrFile
+	if data, err := os.ReadFile(absPath(keyOrFile)); err == nil {
+		encoded = string(data)
+	}
+	encoded = strings.TrimSpace(encoded)
+
+	if _, err := parsePublicKey(encoded); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	keys, err := loadTrustStore()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	id := keyID(encoded)
+	keys[id] = trustedKey{Key: encoded, Name: name, AddedAt: time.Now()}
+	if err := saveTrustStore(keys); err != nil {
+		fmt.Printf("Error writing %s: %v\n", trustStorePath(), err)
+		return
+	}
+
+	fmt.Printf("✅ Trusted key %s\n", id)
+}
+
+func trustRemove(id string) {
+	keys, err := loadTrustStore()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if _, ok := keys[id]; !ok {
+		fmt.Printf("Key %s is not trusted\n", id)
+		return
+	}
+
+	delete(keys, id)
+	if err := saveTrustStore(keys); err != nil {
+		fmt.Printf("Error writing %s: %v\n", trustStorePath(), err)
+		return
+	}
+
+	fmt.Printf("Removed key %s\n", id)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func switchPackage(args []string) {
+	name, version := parsePackageSpec(args[0])
+	if version == "" && len(args) > 1 {
+		version = args[1]
+	}
+	if version == "" {
+		fmt.Println("Error: switch requires a version")
+		fmt.Println("Usage: cupertino switch <package> <version>")
+		return
+	}
+
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	if !db.HasAnyVersion(name) {
+		fmt.Printf("Package '%s' is not installed\n", name)
+		return
+	}
+
+	if !db.IsInstalledVersion(name, version) {
+		versions, _ := db.GetInstalledVersions(name)
+		fmt.Printf("%s v%s is not installed (installed: %s)\n", name, version, strings.Join(versions, ", "))
+		fmt.Printf("Run 'cupertino install --keep %s@%s' to install it alongside\n", name, version)
+		return
+	}
+
+	if active, err := db.Get(name); err == nil && active.Active && active.Version == version {
+		fmt.Printf("%s is already using v%s\n", name, version)
+		return
+	}
+
+	previous, _ := db.GetInstalledVersion(name)
+	if err := switchActiveVersion(db, name, version); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if err := db.RecordHistory(name, previous, version, "switch"); err != nil {
+		fmt.Printf("Warning: could not record history: %v\n", err)
+	}
+
+	fmt.Printf("✅ %s now uses v%s\n", name, version)
+}
+
+// switchActiveVersion re-points bin/ symlinks from the active version of a
+// package to version and records it as active.
+func switchActiveVersion(db *SQLitePackageDB, name, version string) error {
+	target, err := db.GetVersion(name, version)
+	if err != nil {
+		return fmt.Errorf("%s v%s is not installed", name, version)
+	}
+
+	current, err := db.Get(name)
+	if err == nil && current.Active {
+		removeSymlinks(current)
+	}
+
+	if err := createSymlinks(target); err != nil {
+		if current != nil && current.Active {
+			createSymlinks(current)
+		}
+		return err
+	}
+
+	return db.SetActive(name, version)
+}
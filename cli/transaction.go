@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// installTransaction stages packages outside the install tree and then moves
+// them into place together. If any step of the commit fails, the package
+// dirs, bin/ symlinks and packages.db are restored to their previous state.
+// The commit holds the prefix lock, so concurrent installs take turns.
+type installTransaction struct {
+	dir         string
+	staged      []*stagedPackage
+	retired     []*retiredPackage
+	activations []*InstalledPackage
+
+	// keepOtherVersions installs next to existing versions instead of
+	// replacing the active one
+	keepOtherVersions bool
+
+	// Populated during commit for rollback
+	committed   []string
+	dbBackup    string
+	binSnapshot map[string]string
+}
+
+type stagedPackage struct {
+	pkg            *Package
+	registry       string
+	stageDir       string
+	installDir     string
+	installedFiles []string
+}
+
+type retiredPackage struct {
+	pkg       *InstalledPackage
+	backupDir string
+}
+
+func newInstallTransaction() (*installTransaction, error) {
+	stagingRoot := filepath.Join(getCupertinoDir(), ".staging")
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		return nil, fmt.Errorf("creating staging dir: %v", err)
+	}
+
+	dir, err := os.MkdirTemp(stagingRoot, "install-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating staging dir: %v", err)
+	}
+
+	return &installTransaction{dir: dir}, nil
+}
+
+// stage extracts a tarball and copies its files into the staging area. Nothing
+// under the install tree is touched until commit. registry is the URL the
+// tarball came from, or "" for a local file.
+func (tx *installTransaction) stage(tarballPath, registry string) (*Package, error) {
+	extractDir, err := os.MkdirTemp(tx.dir, "extract-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(extractDir)
+
+	fmt.Printf("📦 Extracting %s...\n", filepath.Base(tarballPath))
+
+	if err := extractTarGz(tarballPath, extractDir); err != nil {
+		return nil, fmt.Errorf("extracting tarball: %v", err)
+	}
+
+	pkg, err := parsePackageManifest(filepath.Join(extractDir, "package.json"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing package.json: %v", err)
+	}
+
+	staged := &stagedPackage{
+		pkg:        pkg,
+		registry:   registry,
+		stageDir:   filepath.Join(tx.dir, "packages", pkg.Name, pkg.Version),
+		installDir: filepath.Join(getCupertinoDir(), "packages", pkg.Name, pkg.Version),
+	}
+
+	if err := os.MkdirAll(staged.stageDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating staging dir: %v", err)
+	}
+
+	for srcPath, destPath := range pkg.Files {
+		src := filepath.Join(extractDir, srcPath)
+		dest := filepath.Join(staged.stageDir, destPath)
+
+		if err := copyFile(src, dest); err != nil {
+			return nil, fmt.Errorf("copying %s: %v", srcPath, err)
+		}
+
+		staged.installedFiles = append(staged.installedFiles, filepath.Join(staged.installDir, destPath))
+		fmt.Printf("Copied %s -> %s\n", srcPath, destPath)
+	}
+
+	tx.staged = append(tx.staged, staged)
+	return pkg, nil
+}
+
+// activate queues an already-installed version to become the active one.
+func (tx *installTransaction) activate(name, version string) {
+	tx.activations = append(tx.activations, &InstalledPackage{Package: Package{Name: name, Version: version}})
+}
+
+func (tx *installTransaction) commit() error {
+	if len(tx.staged) == 0 && len(tx.activations) == 0 {
+		return nil
+	}
+
+	// pre_install scripts run in the staging area before anything under the
+	// install tree changes, so a failing one leaves nothing to undo
+	for _, staged := range tx.staged {
+		if err := runLifecycleScripts(staged.pkg, hookPreInstall, staged.stageDir); err != nil {
+			return fmt.Errorf("aborting install: %v", err)
+		}
+	}
+
+	unlock, err := lockPrefix()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := tx.snapshot(); err != nil {
+		return fmt.Errorf("saving install state: %v", err)
+	}
+
+	if err := tx.apply(); err != nil {
+		fmt.Println("Rolling back changes...")
+		if rbErr := tx.rollback(); rbErr != nil {
+			return &incompleteRollbackError{err: err, rollbackErr: rbErr}
+		}
+		return err
+	}
+
+	for _, retired := range tx.retired {
+		if err := runLifecycleScripts(&retired.pkg.Package, hookPostRemove, retired.pkg.InstallPath); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+
+	for _, staged := range tx.staged {
+		if err := runLifecycleScripts(staged.pkg, hookPostInstall, staged.installDir); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		// A failed script may still have changed files, so record them either way
+		if len(getScripts(staged.pkg, hookPostInstall)) == 0 {
+			continue
+		}
+		if err := rerecordFileChecksums(staged.pkg, staged.installedFiles); err != nil {
+			fmt.Printf("Warning: recording files of %s after post_install: %v\n", staged.pkg.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// incompleteRollbackError is returned by commit when an install failed part
+// way and undoing it failed too, so the install tree may be left changed.
+type incompleteRollbackError struct {
+	err         error
+	rollbackErr error
+}
+
+func (e *incompleteRollbackError) Error() string {
+	return fmt.Sprintf("%v (rollback incomplete: %v)", e.err, e.rollbackErr)
+}
+
+func (tx *installTransaction) apply() error {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		return fmt.Errorf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	for _, target := range tx.activations {
+		previous, _ := db.GetInstalledVersion(target.Name)
+		if err := switchActiveVersion(db, target.Name, target.Version); err != nil {
+			return fmt.Errorf("activating %s v%s: %v", target.Name, target.Version, err)
+		}
+		if err := db.RecordHistory(target.Name, previous, target.Version, "switch"); err != nil {
+			return fmt.Errorf("updating database: %v", err)
+		}
+	}
+
+	for _, staged := range tx.staged {
+		pkg := staged.pkg
+
+		previousVersion := ""
+
+		// Reinstalling an existing version replaces it
+		if db.IsInstalledVersion(pkg.Name, pkg.Version) {
+			existing, err := db.GetVersion(pkg.Name, pkg.Version)
+			if err != nil {
+				return fmt.Errorf("reading installed %s: %v", pkg.Name, err)
+			}
+			if existing.Active {
+				previousVersion = existing.Version
+			}
+			if err := tx.retire(db, existing); err != nil {
+				return fmt.Errorf("removing existing %s: %v", pkg.Name, err)
+			}
+		}
+
+		if active, err := db.Get(pkg.Name); err == nil && active.Active {
+			previousVersion = active.Version
+			if tx.keepOtherVersions {
+				removeSymlinks(active)
+			} else if err := tx.retain(db, active); err != nil {
+				return fmt.Errorf("keeping previous version of %s: %v", pkg.Name, err)
+			}
+		}
+
+		fmt.Printf("Installing %s v%s...\n", pkg.Name, pkg.Version)
+
+		if err := os.MkdirAll(filepath.Dir(staged.installDir), 0755); err != nil {
+			return fmt.Errorf("creating package dir: %v", err)
+		}
+		if err := os.Rename(staged.stageDir, staged.installDir); err != nil {
+			return fmt.Errorf("moving %s into place: %v", pkg.Name, err)
+		}
+		tx.committed = append(tx.committed, staged.installDir)
+
+		checksums, err := recordFileChecksums(staged.installedFiles)
+		if err != nil {
+			return fmt.Errorf("recording checksums for %s: %v", pkg.Name, err)
+		}
+
+		installedPkg := &InstalledPackage{
+			Package:        *pkg,
+			InstallPath:    staged.installDir,
+			InstalledFiles: staged.installedFiles,
+			FileChecksums:  checksums,
+			InstallDate:    time.Now(),
+			Registry:       staged.registry,
+		}
+
+		if err := db.Install(installedPkg); err != nil {
+			return fmt.Errorf("updating database: %v", err)
+		}
+		if err := db.RecordHistory(pkg.Name, previousVersion, pkg.Version, historyAction(previousVersion, pkg.Version)); err != nil {
+			return fmt.Errorf("updating database: %v", err)
+		}
+
+		if err := createSymlinks(installedPkg); err != nil {
+			return fmt.Errorf("linking %s: %v", pkg.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// retain unlinks the version being replaced but leaves its files installed so
+// `cupertino rollback` can restore it. Only the most recent replaced version
+// is kept; older retained versions are retired.
+func (tx *installTransaction) retain(db *SQLitePackageDB, pkg *InstalledPackage) error {
+	versions, err := db.ListVersions(pkg.Name)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version.Retained && version.Version != pkg.Version {
+			if err := tx.retire(db, version); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Printf("Keeping %s v%s for rollback\n", pkg.Name, pkg.Version)
+	removeSymlinks(pkg)
+	return db.SetRetained(pkg.Name, pkg.Version, true)
+}
+
+// historyAction describes a change of active version for the history log.
+func historyAction(from, to string) string {
+	if from == "" {
+		return "install"
+	}
+
+	fromVersion, err1 := ParseVersion(from)
+	toVersion, err2 := ParseVersion(to)
+	if err1 != nil || err2 != nil {
+		return "install"
+	}
+
+	switch cmp := toVersion.Compare(fromVersion); {
+	case cmp > 0:
+		return "upgrade"
+	case cmp < 0:
+		return "downgrade"
+	default:
+		return "reinstall"
+	}
+}
+
+// retire moves an installed package out of the install tree so it can be
+// restored if the transaction rolls back.
+func (tx *installTransaction) retire(db *SQLitePackageDB, pkg *InstalledPackage) error {
+	fmt.Printf("Removing previous version of %s (v%s)...\n", pkg.Name, pkg.Version)
+
+	if err := runLifecycleScripts(&pkg.Package, hookPreRemove, pkg.InstallPath); err != nil {
+		return err
+	}
+
+	removeSymlinks(pkg)
+
+	retired := &retiredPackage{pkg: pkg}
+	if _, err := os.Stat(pkg.InstallPath); err == nil {
+		retired.backupDir = filepath.Join(tx.dir, "retired", pkg.Name, pkg.Version)
+		if err := os.MkdirAll(filepath.Dir(retired.backupDir), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(pkg.InstallPath, retired.backupDir); err != nil {
+			return err
+		}
+	}
+	tx.retired = append(tx.retired, retired)
+
+	return db.RemoveVersion(pkg.Name, pkg.Version)
+}
+
+func (tx *installTransaction) snapshot() error {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx.dbBackup = filepath.Join(tx.dir, "packages.db")
+	if err := db.Backup(tx.dbBackup); err != nil {
+		return err
+	}
+
+	tx.binSnapshot = make(map[string]string)
+	entries, err := os.ReadDir(getBinDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		linkPath := filepath.Join(getBinDir(), entry.Name())
+		if target, err := os.Readlink(linkPath); err == nil {
+			tx.binSnapshot[entry.Name()] = target
+		}
+	}
+
+	return nil
+}
+
+func (tx *installTransaction) rollback() error {
+	var firstErr error
+	record := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for i := len(tx.committed) - 1; i >= 0; i-- {
+		record(os.RemoveAll(tx.committed[i]))
+		cleanupEmptyDirs(filepath.Dir(tx.committed[i]))
+	}
+
+	for i := len(tx.retired) - 1; i >= 0; i-- {
+		retired := tx.retired[i]
+		if retired.backupDir == "" {
+			continue
+		}
+		record(os.MkdirAll(filepath.Dir(retired.pkg.InstallPath), 0755))
+		record(os.Rename(retired.backupDir, retired.pkg.InstallPath))
+	}
+
+	record(restoreBinSnapshot(tx.binSnapshot))
+
+	if db, err := NewSQLitePackageDB(getDatabasePath()); err != nil {
+		record(err)
+	} else {
+		record(db.Restore(tx.dbBackup))
+		db.Close()
+	}
+
+	return firstErr
+}
+
+func restoreBinSnapshot(snapshot map[string]string) error {
+	binDir := getBinDir()
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		linkPath := filepath.Join(binDir, entry.Name())
+		target, err := os.Readlink(linkPath)
+		if err != nil {
+			continue // Not a symlink, leave it alone
+		}
+		if snapshot[entry.Name()] != target {
+			os.Remove(linkPath)
+		}
+	}
+
+	for name, target := range snapshot {
+		linkPath := filepath.Join(binDir, name)
+		if current, err := os.Readlink(linkPath); err == nil && current == target {
+			continue
+		}
+		if err := os.MkdirAll(binDir, 0755); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, linkPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tx *installTransaction) cleanup() {
+	os.RemoveAll(tx.dir)
+	os.Remove(filepath.Dir(tx.dir))
+}
@@ -8,8 +8,43 @@ import (
 	"strings"
 )
 
+const defaultPrefix = "/opt/cupertino"
+
+var cupertinoDir string
+
+// getCupertinoDir returns the install prefix from the "prefix" setting
+// (--prefix, CUPERTINO_HOME or a config file).
 func getCupertinoDir() string {
-	return "/opt/cupertino"
+	if cupertinoDir == "" {
+		cupertinoDir = absPath(configValue("prefix"))
+	}
+	return cupertinoDir
+}
+
+// defaultPrefixDir is /opt/cupertino if it exists, otherwise ~/.cupertino so
+// installs work without root.
+func defaultPrefixDir() string {
+	if _, err := os.Stat(defaultPrefix); err == nil {
+		return defaultPrefix
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".cupertino")
+	}
+	return defaultPrefix
+}
+
+// absPath expands a leading ~ and makes path absolute, since symlinks into
+// the prefix must not depend on the working directory.
+func absPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
 }
 
 func getDatabasePath() string {
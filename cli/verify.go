@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// recordFileChecksums hashes installed files so later changes can be detected
+// by `cupertino verify`.
+func recordFileChecksums(paths []string) (map[string]FileChecksum, error) {
+	checksums := make(map[string]FileChecksum, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return nil, err
+		}
+		checksums[path] = FileChecksum{SHA256: sum, Size: info.Size(), Mode: info.Mode().Perm()}
+	}
+	return checksums, nil
+}
+
+// rerecordFileChecksums hashes a package's files again once its post_install
+// scripts have run, so changes the scripts make aren't reported by
+// `cupertino verify`. Files the scripts delete keep their old checksums.
+func rerecordFileChecksums(pkg *Package, installedFiles []string) error {
+	var present []string
+	for _, path := range installedFiles {
+		if _, err := os.Lstat(path); err == nil {
+			present = append(present, path)
+		}
+	}
+
+	checksums, err := recordFileChecksums(present)
+	if err != nil {
+		return err
+	}
+
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.SetFileChecksums(pkg.Name, pkg.Version, checksums)
+}
+
+// fileProblem is a difference between an installed file and what was
+// recorded when it was installed.
+type fileProblem struct {
+	kind   string // "missing", "modified", "mode", "extra"
+	path   string
+	detail string
+}
+
+// verifyPackage compares a package's files on disk against the database. It
+// also returns how many files had no recorded checksum to compare against.
+// Files that post_install scripts may have created aren't known, so packages
+// with post_install scripts aren't checked for extra files.
+func verifyPackage(pkg *InstalledPackage) ([]fileProblem, int, error) {
+	var problems []fileProblem
+	unrecorded := 0
+
+	owned := make(map[string]bool, len(pkg.InstalledFiles))
+	for _, path := range pkg.InstalledFiles {
+		owned[path] = true
+
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			problems = append(problems, fileProblem{kind: "missing", path: path})
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		recorded, ok := pkg.FileChecksums[path]
+		if !ok {
+			unrecorded++
+			continue
+		}
+
+		if info.Size() != recorded.Size {
+			problems = append(problems, fileProblem{kind: "modified", path: path,
+				detail: fmt.Sprintf("size %s, was %s", formatBytes(info.Size()), formatBytes(recorded.Size))})
+		} else if sum, err := fileChecksum(path); err != nil {
+			return nil, 0, err
+		} else if sum != recorded.SHA256 {
+			problems = append(problems, fileProblem{kind: "modified", path: path, detail: "contents changed"})
+		}
+
+		if mode := info.Mode().Perm(); mode != recorded.Mode {
+			problems = append(problems, fileProblem{kind: "mode", path: path,
+				detail: fmt.Sprintf("%04o, was %04o", mode, recorded.Mode)})
+		}
+	}
+
+	if len(pkg.PostInstall) > 0 {
+		return problems, unrecorded, nil
+	}
+
+	err := filepath.Walk(pkg.InstallPath, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == pkg.InstallPath {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !owned[path] {
+			problems = append(problems, fileProblem{kind: "extra", path: path})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return problems, unrecorded, nil
+}
+
+// verify checks installed files against the checksums recorded at install
+// time and exits non-zero if any were modified, removed or added.
+func verify(args []string) {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	var packages []*InstalledPackage
+	if len(args) > 0 {
+		name, version := parsePackageSpec(args[0])
+		if version != "" {
+			pkg, err := db.GetVersion(name, version)
+			if err != nil {
+				fmt.Printf("%s v%s is not installed\n", name, version)
+				os.Exit(1)
+			}
+			packages = append(packages, pkg)
+		} else {
+			if !db.HasAnyVersion(name) {
+				fmt.Printf("Package '%s' is not installed\n", name)
+				os.Exit(1)
+			}
+			packages, err = db.ListVersions(name)
+		}
+	} else {
+		packages, err = db.ListAll()
+	}
+	if err != nil {
+		fmt.Printf("Error reading installed packages: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(packages) == 0 {
+		fmt.Println("No packages installed")
+		return
+	}
+
+	failed := 0
+	for _, pkg := range packages {
+		problems, unrecorded, err := verifyPackage(pkg)
+		if err != nil {
+			fmt.Printf("❌ %s v%s: %v\n", pkg.Name, pkg.Version, err)
+			failed++
+			continue
+		}
+
+		if len(problems) == 0 {
+			fmt.Printf("✅ %s v%s\n", pkg.Name, pkg.Version)
+		} else {
+			failed++
+			fmt.Printf("❌ %s v%s\n", pkg.Name, pkg.Version)
+			sort.Slice(problems, func(i, j int) bool { return problems[i].path < problems[j].path })
+			for _, problem := range problems {
+				if problem.detail != "" {
+					fmt.Printf("   %-9s %s (%s)\n", problem.kind+":", problem.path, problem.detail)
+				} else {
+					fmt.Printf("   %-9s %s\n", problem.kind+":", problem.path)
+				}
+			}
+		}
+		if len(pkg.PostInstall) > 0 {
+			fmt.Println("   not checked for extra files, since its post_install scripts may add some")
+		}
+		if unrecorded > 0 {
+			fmt.Printf("   %d file(s) installed without checksums; reinstall to record them\n", unrecorded)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d of %d package(s) failed verification\n", failed, len(packages))
+		fmt.Println("Reinstall a package with 'cupertino install <package>@<version>' to restore its files")
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nAll %d package(s) verified\n", len(packages))
+}
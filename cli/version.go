@@ -2,74 +2,334 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// Version is a SemVer 2.0 version. Two-part versions such as "1.2" are
+// accepted and treated as "1.2.0".
 type Version struct {
-	Major int
-	Minor int
-	Patch int
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease []string // dot-separated identifiers after "-", e.g. ["beta", "1"]
+	Build      string   // metadata after "+", ignored for precedence
 }
 
+// VersionConstraint is a parsed range expression. It matches a version when
+// any of its comparator sets (separated by "||") matches.
 type VersionConstraint struct {
-	Operator string // >=, ^, ~, =, *
+	Raw  string
+	Sets []ComparatorSet
+}
+
+// ComparatorSet matches a version when every comparator in it does. An empty
+// set matches any release version.
+type ComparatorSet []Comparator
+
+type Comparator struct {
+	Operator string // =, !=, <, <=, >, >=
 	Version  Version
 }
 
 func ParseVersion(versionStr string) (Version, error) {
-	parts := strings.Split(versionStr, ".")
-	if len(parts) != 3 {
+	core := strings.TrimPrefix(strings.TrimSpace(versionStr), "v")
+
+	var version Version
+
+	if before, build, ok := strings.Cut(core, "+"); ok {
+		if err := validateIdentifiers(build, false); err != nil {
+			return Version{}, fmt.Errorf("invalid build metadata in %s: %v", versionStr, err)
+		}
+		core, version.Build = before, build
+	}
+
+	if before, prerelease, ok := strings.Cut(core, "-"); ok {
+		if err := validateIdentifiers(prerelease, true); err != nil {
+			return Version{}, fmt.Errorf("invalid pre-release in %s: %v", versionStr, err)
+		}
+		core, version.Prerelease = before, strings.Split(prerelease, ".")
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 2 && len(parts) != 3 {
 		return Version{}, fmt.Errorf("invalid version format: %s", versionStr)
 	}
 
-	major, err := strconv.Atoi(parts[0])
+	major, err := parseVersionNumber(parts[0])
 	if err != nil {
 		return Version{}, fmt.Errorf("invalid major version: %s", parts[0])
 	}
 
-	minor, err := strconv.Atoi(parts[1])
+	minor, err := parseVersionNumber(parts[1])
 	if err != nil {
 		return Version{}, fmt.Errorf("invalid minor version: %s", parts[1])
 	}
 
-	patch, err := strconv.Atoi(parts[2])
-	if err != nil {
-		return Version{}, fmt.Errorf("invalid patch version: %s", parts[2])
+	patch := 0
+	if len(parts) == 3 {
+		patch, err = parseVersionNumber(parts[2])
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid patch version: %s", parts[2])
+		}
 	}
 
-	return Version{
-		Major: major,
-		Minor: minor,
-		Patch: patch,
-	}, nil
+	version.Major = major
+	version.Minor = minor
+	version.Patch = patch
+	return version, nil
+}
+
+// parseVersionNumber parses a major, minor or patch number, which like a
+// numeric pre-release identifier must not have leading zeros.
+func parseVersionNumber(s string) (int, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("not a number: %q", s)
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("leading zero in %q", s)
+	}
+	return strconv.Atoi(s)
+}
+
+// validateIdentifiers checks dot-separated pre-release or build identifiers.
+// Numeric pre-release identifiers must not have leading zeros.
+func validateIdentifiers(s string, prerelease bool) error {
+	for _, ident := range strings.Split(s, ".") {
+		if ident == "" {
+			return fmt.Errorf("empty identifier")
+		}
+		for _, r := range ident {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return fmt.Errorf("invalid character %q in %q", r, ident)
+			}
+		}
+		if prerelease && isNumericIdentifier(ident) && len(ident) > 1 && ident[0] == '0' {
+			return fmt.Errorf("leading zero in %q", ident)
+		}
+	}
+	return nil
+}
+
+func isNumericIdentifier(ident string) bool {
+	return ident != "" && strings.TrimLeft(ident, "0123456789") == ""
+}
+
+func (v Version) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.IsPrerelease() {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
 }
 
+// ParseConstraint parses a range expression such as ">=1.2.0 <2.0.0",
+// "^1.0 || ^2.0", "1.x", "1.2.0 - 1.4" or "!=1.4.3". An empty constraint
+// matches any version, but a blank alternative such as ">=1 ||" is an error.
 func ParseConstraint(constraintStr string) (VersionConstraint, error) {
-	if constraintStr == "*" {
-		return VersionConstraint{Operator: "*"}, nil
+	constraint := VersionConstraint{Raw: strings.TrimSpace(constraintStr)}
+
+	for _, part := range strings.Split(constraint.Raw, "||") {
+		if constraint.Raw != "" && strings.TrimSpace(part) == "" {
+			return VersionConstraint{}, fmt.Errorf("invalid constraint %q: empty range around ||", constraintStr)
+		}
+		set, err := parseComparatorSet(part)
+		if err != nil {
+			return VersionConstraint{}, fmt.Errorf("invalid constraint %q: %v", constraintStr, err)
+		}
+		constraint.Sets = append(constraint.Sets, set)
+	}
+
+	return constraint, nil
+}
+
+func parseComparatorSet(s string) (ComparatorSet, error) {
+	fields := strings.Fields(strings.ReplaceAll(s, ",", " "))
+
+	// Hyphen range: "1.2.0 - 2.0"
+	if len(fields) == 3 && fields[1] == "-" {
+		lower, _, err := parsePartialVersion(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		upper, n, err := parsePartialVersion(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		set := ComparatorSet{{">=", lower}}
+		switch {
+		case n == 0:
+		case n < 3:
+			set = append(set, Comparator{"<", bumpVersion(upper, n)})
+		default:
+			set = append(set, Comparator{"<=", upper})
+		}
+		return set, nil
+	}
+
+	// Allow whitespace between an operator and its version: ">= 1.2.0"
+	var tokens []string
+	for i := 0; i < len(fields); i++ {
+		token := fields[i]
+		if strings.TrimLeft(token, "<>=!^~") == "" && i+1 < len(fields) {
+			token += fields[i+1]
+			i++
+		}
+		tokens = append(tokens, token)
+	}
+
+	set := ComparatorSet{}
+	for _, token := range tokens {
+		comparators, err := expandComparator(token)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, comparators...)
+	}
+	return set, nil
+}
+
+// expandComparator turns one operator and a possibly partial version into
+// primitive comparators.
+func expandComparator(token string) ([]Comparator, error) {
+	operator := ""
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if after, ok := strings.CutPrefix(token, op); ok {
+			operator, token = op, after
+			break
+		}
+	}
+
+	version, n, err := parsePartialVersion(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		switch operator {
+		case "", "=", ">=", "<=", "^", "~":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s cannot be used with a wildcard", operator)
+	}
+
+	switch operator {
+	case "", "=":
+		if n < 3 {
+			return []Comparator{{">=", version}, {"<", bumpVersion(version, n)}}, nil
+		}
+		return []Comparator{{"=", version}}, nil
+	case "!=":
+		if n < 3 {
+			return nil, fmt.Errorf("!= requires a full version")
+		}
+		return []Comparator{{"!=", version}}, nil
+	case ">=", "<":
+		return []Comparator{{operator, version}}, nil
+	case ">":
+		if n < 3 {
+			return []Comparator{{">=", bumpVersion(version, n)}}, nil
+		}
+		return []Comparator{{">", version}}, nil
+	case "<=":
+		if n < 3 {
+			return []Comparator{{"<", bumpVersion(version, n)}}, nil
+		}
+		return []Comparator{{"<=", version}}, nil
+	case "^":
+		// Compatible within same major version
+		return []Comparator{{">=", version}, {"<", bumpVersion(version, 1)}}, nil
+	case "~":
+		// Compatible within same major.minor
+		if n == 1 {
+			return []Comparator{{">=", version}, {"<", bumpVersion(version, 1)}}, nil
+		}
+		return []Comparator{{">=", version}, {"<", bumpVersion(version, 2)}}, nil
 	}
 
-	if after, ok := strings.CutPrefix(constraintStr, ">="); ok {
-		version, err := ParseVersion(after)
-		return VersionConstraint{Operator: ">=", Version: version}, err
+	return nil, fmt.Errorf("unknown operator %q", operator)
+}
+
+// parsePartialVersion parses versions that may omit components or use x/X/*
+// wildcards. It returns how many numeric components were given.
+func parsePartialVersion(s string) (Version, int, error) {
+	s = strings.TrimPrefix(s, "v")
+	if s == "" || s == "*" || s == "x" || s == "X" {
+		return Version{}, 0, nil
 	}
 
-	if after, ok := strings.CutPrefix(constraintStr, "^"); ok {
-		version, err := ParseVersion(after)
-		return VersionConstraint{Operator: "^", Version: version}, err
+	core := s
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		core = s[:i]
 	}
 
-	if after, ok := strings.CutPrefix(constraintStr, "~"); ok {
-		version, err := ParseVersion(after)
-		return VersionConstraint{Operator: "~", Version: version}, err
+	fields := strings.Split(core, ".")
+	if len(fields) > 3 {
+		return Version{}, 0, fmt.Errorf("invalid version format: %s", s)
 	}
 
-	// Default to exact match
-	version, err := ParseVersion(constraintStr)
-	return VersionConstraint{Operator: "=", Version: version}, err
+	var nums [3]int
+	n := 0
+	for i, field := range fields {
+		if field == "x" || field == "X" || field == "*" {
+			for _, rest := range fields[i+1:] {
+				if rest != "x" && rest != "X" && rest != "*" {
+					return Version{}, 0, fmt.Errorf("invalid version format: %s", s)
+				}
+			}
+			break
+		}
+
+		num, err := parseVersionNumber(field)
+		if err != nil {
+			return Version{}, 0, fmt.Errorf("invalid version format: %s", s)
+		}
+		nums[i] = num
+		n++
+	}
+
+	if core != s {
+		if n < 3 {
+			return Version{}, 0, fmt.Errorf("pre-release or build requires a full version: %s", s)
+		}
+		version, err := ParseVersion(s)
+		return version, 3, err
+	}
+
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, n, nil
+}
+
+// bumpVersion returns the lowest version above the range given by the first
+// n components of v, e.g. bumpVersion(1.2.x, 2) is 1.3.0.
+func bumpVersion(v Version, n int) Version {
+	if n == 1 {
+		return Version{Major: v.Major + 1}
+	}
+	return Version{Major: v.Major, Minor: v.Minor + 1}
 }
 
+// ExactVersion reports whether the constraint pins a single version.
+func (constraint VersionConstraint) ExactVersion() (Version, bool) {
+	if len(constraint.Sets) == 1 && len(constraint.Sets[0]) == 1 && constraint.Sets[0][0].Operator == "=" {
+		return constraint.Sets[0][0].Version, true
+	}
+	return Version{}, false
+}
+
+func (constraint VersionConstraint) String() string {
+	return constraint.Raw
+}
+
+// Compare orders versions by SemVer 2.0 precedence. Build metadata is ignored.
 func (v Version) Compare(other Version) int {
 	if v.Major != other.Major {
 		return v.Major - other.Major
@@ -79,26 +339,123 @@ func (v Version) Compare(other Version) int {
 		return v.Minor - other.Minor
 	}
 
-	return v.Patch - other.Patch
+	if v.Patch != other.Patch {
+		return v.Patch - other.Patch
+	}
+
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+func comparePrerelease(a, b []string) int {
+	// A version without a pre-release has higher precedence
+	if len(a) == 0 || len(b) == 0 {
+		return len(b) - len(a)
+	}
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		aNum, bNum := isNumericIdentifier(a[i]), isNumericIdentifier(b[i])
+
+		switch {
+		case aNum && bNum:
+			x, _ := strconv.Atoi(a[i])
+			y, _ := strconv.Atoi(b[i])
+			if x != y {
+				return x - y
+			}
+		case aNum:
+			return -1
+		case bNum:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+// sortVersionsDesc returns the valid versions from versions, newest first.
+func sortVersionsDesc(versions []string) []string {
+	type parsedVersion struct {
+		raw     string
+		version Version
+	}
+
+	var parsed []parsedVersion
+	for _, versionStr := range versions {
+		version, err := ParseVersion(versionStr)
+		if err != nil {
+			continue // Skip invalid versions
+		}
+		parsed = append(parsed, parsedVersion{versionStr, version})
+	}
+
+	sort.SliceStable(parsed, func(i, j int) bool {
+		return parsed[i].version.Compare(parsed[j].version) > 0
+	})
+
+	sorted := make([]string, len(parsed))
+	for i, p := range parsed {
+		sorted[i] = p.raw
+	}
+	return sorted
+}
+
+func (v Version) sameCore(other Version) bool {
+	return v.Major == other.Major && v.Minor == other.Minor && v.Patch == other.Patch
 }
 
 func (constraint VersionConstraint) Satisfies(version Version) bool {
-	switch constraint.Operator {
-	case "*":
-		return true
+	for _, set := range constraint.Sets {
+		if set.Satisfies(version) {
+			return true
+		}
+	}
+	return false
+}
+
+func (set ComparatorSet) Satisfies(version Version) bool {
+	// Pre-releases only match when a comparator opts in with a pre-release
+	// of the same major.minor.patch, e.g. ^1.2.0-beta.1 matches 1.2.0-beta.3
+	if version.IsPrerelease() {
+		allowed := false
+		for _, c := range set {
+			if c.Version.IsPrerelease() && c.Version.sameCore(version) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	for _, c := range set {
+		if !c.Satisfies(version) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Comparator) Satisfies(version Version) bool {
+	cmp := version.Compare(c.Version)
+
+	switch c.Operator {
 	case "=":
-		return constraint.Version.Compare(version) == 0
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
 	case ">=":
-		return version.Compare(constraint.Version) >= 0
-	case "^":
-		// Compatible within same major version
-		return version.Major == constraint.Version.Major &&
-			version.Compare(constraint.Version) >= 0
-	case "~":
-		// Compatible within same major.minor
-		return version.Major == constraint.Version.Major &&
-			version.Minor == constraint.Version.Minor &&
-			version.Compare(constraint.Version) >= 0
+		return cmp >= 0
 	}
 
 	return false
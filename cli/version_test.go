@@ -0,0 +1,222 @@
+package main
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1.2.3", "1.2.3"},
+		{"v1.2.3", "1.2.3"},
+		{"1.2", "1.2.0"},
+		{"0.0.0", "0.0.0"},
+		{"10.20.30", "10.20.30"},
+		{"1.2.3-0", "1.2.3-0"},
+		{"1.2.3-beta.1", "1.2.3-beta.1"},
+		{"1.2.3-x-y.0a", "1.2.3-x-y.0a"},
+		{"1.2.3+build.01", "1.2.3+build.01"},
+		{"1.2.3-rc.1+sha.5114f85", "1.2.3-rc.1+sha.5114f85"},
+	}
+
+	for _, tt := range tests {
+		version, err := ParseVersion(tt.input)
+		if err != nil {
+			t.Errorf("ParseVersion(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got := version.String(); got != tt.want {
+			t.Errorf("ParseVersion(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseVersionRejects(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"1",
+		"1.2.3.4",
+		"a.b.c",
+		"1..3",
+		"01.2.3",
+		"1.02.3",
+		"1.2.03",
+		"1.2.3-",
+		"1.2.3-01",
+		"1.2.3-beta..1",
+		"1.2.3-be_ta",
+		"1.2.3+",
+		"1.2.3+build+meta",
+	} {
+		if version, err := ParseVersion(input); err == nil {
+			t.Errorf("ParseVersion(%q) = %s, want an error", input, version)
+		}
+	}
+}
+
+func TestVersionPrecedence(t *testing.T) {
+	// In increasing order of precedence, from SemVer 2.0 §11
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"2.0.0",
+		"2.1.0",
+		"2.1.1",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseVersion(ordered[i])
+			b, _ := ParseVersion(ordered[j])
+
+			got := a.Compare(b)
+			switch {
+			case i < j && got >= 0, i > j && got <= 0, i == j && got != 0:
+				t.Errorf("Compare(%s, %s) = %d", ordered[i], ordered[j], got)
+			}
+		}
+	}
+}
+
+func TestVersionCompareIgnoresBuild(t *testing.T) {
+	a, _ := ParseVersion("1.0.0+20130313144700")
+	b, _ := ParseVersion("1.0.0+exp.sha.5114f85")
+	if a.Compare(b) != 0 {
+		t.Errorf("versions differing only in build metadata compare as %d", a.Compare(b))
+	}
+}
+
+func TestSortVersionsDesc(t *testing.T) {
+	got := sortVersionsDesc([]string{"1.0.0", "not-a-version", "1.10.0", "1.2.0", "1.10.0-rc.1"})
+	want := []string{"1.10.0", "1.10.0-rc.1", "1.2.0", "1.0.0"}
+
+	if len(got) != len(want) {
+		t.Fatalf("sortVersionsDesc = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sortVersionsDesc = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestConstraintSatisfies(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"", "1.0.0", true},
+		{"*", "3.1.4", true},
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{"^1.2.0", "1.2.0", true},
+		{"^1.2.0", "1.9.9", true},
+		{"^1.2.0", "1.1.9", false},
+		{"^1.2.0", "2.0.0", false},
+		{"~1.2", "1.2.5", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{">=1.2.0 <2.0.0", "1.5.0", true},
+		{">=1.2.0 <2.0.0", "2.0.0", false},
+		{">= 1.2.0, < 2", "1.5.0", true},
+		{">1.2", "1.3.0", true},
+		{">1.2", "1.2.9", false},
+		{"<=1.2", "1.2.9", true},
+		{"<=1.2", "1.3.0", false},
+		{"!=1.4.3", "1.4.3", false},
+		{"!=1.4.3", "1.4.4", true},
+		{"1.x", "1.0.0", true},
+		{"1.x", "2.0.0", false},
+		{"1.2.*", "1.2.7", true},
+		{"1.2.*", "1.3.0", false},
+		{"^1.0 || ^3.0", "3.1.0", true},
+		{"^1.0 || ^3.0", "2.0.0", false},
+
+		// Hyphen ranges include a full upper bound and everything below a
+		// partial one
+		{"1.2.0 - 1.4.2", "1.4.2", true},
+		{"1.2.0 - 1.4.2", "1.4.3", false},
+		{"1.2.0 - 1.4", "1.4.9", true},
+		{"1.2.0 - 1.4", "1.5.0", false},
+		{"1.2.0 - 1.4", "1.1.9", false},
+		{"1.2 - 2", "2.9.0", true},
+		{"1.2 - 2", "3.0.0", false},
+
+		// Pre-releases only match when the range opts in on the same core
+		{"^1.2.0", "1.3.0-beta", false},
+		{">=1.0.0", "2.0.0-rc.1", false},
+		{"*", "1.0.0-alpha", false},
+		{"^1.2.0-beta.1", "1.2.0-beta.3", true},
+		{"^1.2.0-beta.1", "1.2.0-alpha", false},
+		{"^1.2.0-beta.1", "1.2.0", true},
+		{"^1.2.0-beta.1", "1.3.0-beta", false},
+		{">=1.2.0-rc.1 <1.2.1", "1.2.0-rc.2", true},
+	}
+
+	for _, tt := range tests {
+		constraint, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) failed: %v", tt.constraint, err)
+			continue
+		}
+		version, err := ParseVersion(tt.version)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q) failed: %v", tt.version, err)
+		}
+		if got := constraint.Satisfies(version); got != tt.want {
+			t.Errorf("%q.Satisfies(%s) = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseConstraintRejects(t *testing.T) {
+	for _, input := range []string{
+		">=1 ||",
+		"|| <2",
+		"1.0 || || 2.0",
+		"||",
+		">x",
+		"!=1.2",
+		"1.x.3",
+		"1.2.3.4",
+		"01.2.3",
+		"^1.02",
+		"^1.2-beta",
+		"~>1.2",
+		"abc",
+	} {
+		if constraint, err := ParseConstraint(input); err == nil {
+			t.Errorf("ParseConstraint(%q) = %v, want an error", input, constraint.Sets)
+		}
+	}
+}
+
+func TestExactVersion(t *testing.T) {
+	tests := []struct {
+		constraint string
+		exact      bool
+	}{
+		{"1.2.3", true},
+		{"=1.2.3", true},
+		{"1.2", false},
+		{"^1.2.3", false},
+		{"1.2.3 || 1.2.4", false},
+	}
+
+	for _, tt := range tests {
+		constraint, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%q) failed: %v", tt.constraint, err)
+		}
+		if _, exact := constraint.ExactVersion(); exact != tt.exact {
+			t.Errorf("%q.ExactVersion() exact = %v, want %v", tt.constraint, exact, tt.exact)
+		}
+	}
+}
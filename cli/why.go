@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// whyStep is a package on a dependency path and the constraint it places on
+// the next package down the path ("" for the last one).
+type whyStep struct {
+	pkg        *InstalledPackage
+	constraint string
+}
+
+// why explains why a package is installed: every path from a package the user
+// asked for down to it, or that it is an orphan if nothing needs it.
+func why(name string) {
+	db, err := NewSQLitePackageDB(getDatabasePath())
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	installed, err := activePackages(db)
+	if err != nil {
+		fmt.Printf("Error reading installed packages: %v\n", err)
+		return
+	}
+
+	target, ok := installed[name]
+	if !ok {
+		fmt.Printf("Package '%s' is not installed\n", name)
+		return
+	}
+
+	// dependents[name] lists the installed packages that depend on name
+	dependents := make(map[string][]whyStep)
+	for _, pkg := range installed {
+		for depName, constraint := range pkg.Dependencies {
+			dependents[depName] = append(dependents[depName], whyStep{pkg: pkg, constraint: constraint})
+		}
+	}
+	for _, steps := range dependents {
+		sort.Slice(steps, func(i, j int) bool { return steps[i].pkg.Name < steps[j].pkg.Name })
+	}
+
+	var explicitPaths, orphanPaths [][]whyStep
+	onPath := map[string]bool{target.Name: true}
+
+	// walk extends path, which runs from some package down to the target,
+	// upwards through everything that depends on its first package
+	var walk func(path []whyStep)
+	walk = func(path []whyStep) {
+		top := path[0].pkg
+		if len(path) > 1 && top.Explicit {
+			explicitPaths = append(explicitPaths, path)
+		}
+
+		parents := dependents[top.Name]
+		if len(parents) == 0 && len(path) > 1 && !top.Explicit {
+			orphanPaths = append(orphanPaths, path)
+		}
+
+		for _, parent := range parents {
+			if onPath[parent.pkg.Name] {
+				continue // a dependency cycle
+			}
+			onPath[parent.pkg.Name] = true
+			walk(append([]whyStep{parent}, path...))
+			onPath[parent.pkg.Name] = false
+		}
+	}
+	walk([]whyStep{{pkg: target}})
+
+	fmt.Printf("%s v%s\n", target.Name, target.Version)
+	if target.Explicit {
+		fmt.Println("  installed explicitly")
+	}
+
+	if len(dependents[target.Name]) == 0 {
+		if target.Explicit {
+			fmt.Println("\nNothing depends on it.")
+		} else {
+			fmt.Printf("\nNothing depends on %s and it wasn't installed explicitly, so it is an orphan.\n", target.Name)
+			fmt.Printf("Remove it with 'cupertino uninstall %s'\n", target.Name)
+		}
+		return
+	}
+
+	if len(explicitPaths) > 0 {
+		fmt.Println("\nRequired by:")
+		for _, path := range explicitPaths {
+			fmt.Printf("  %s\n", formatWhyPath(path))
+		}
+	}
+
+	if len(orphanPaths) > 0 {
+		fmt.Println("\nRequired through orphans (packages nothing needs that weren't installed explicitly):")
+		for _, path := range orphanPaths {
+			fmt.Printf("  %s\n", formatWhyPath(path))
+		}
+		if len(explicitPaths) == 0 && !target.Explicit {
+			fmt.Printf("\nNo package you installed needs %s; uninstalling the orphans above would leave it unused.\n", target.Name)
+		}
+	}
+}
+
+// formatWhyPath prints a path as "app v1.0.0 -> liba ^1.0.0 (v1.0.2) -> ...",
+// giving each package's constraint and the installed version that meets it.
+func formatWhyPath(path []whyStep) string {
+	parts := []string{fmt.Sprintf("%s v%s", path[0].pkg.Name, path[0].pkg.Version)}
+	for i := 1; i < len(path); i++ {
+		pkg := path[i].pkg
+		parts = append(parts, fmt.Sprintf("%s %s (v%s)", pkg.Name, path[i-1].constraint, pkg.Version))
+	}
+	return strings.Join(parts, " -> ")
+}
@@ -60,8 +60,10 @@ ohai() {
 # Get user info
 USER="${USER:-$(id -un)}"
 
-# Set prefix (allow override via environment)
-CUPERTINO_PREFIX="${CUPERTINO_PREFIX:-/opt/cupertino}"
+# Set prefix (allow override via environment, e.g. CUPERTINO_HOME=~/.cupertino
+# for an install that doesn't need sudo)
+DEFAULT_PREFIX="/opt/cupertino"
+CUPERTINO_PREFIX="${CUPERTINO_HOME:-${CUPERTINO_PREFIX:-${DEFAULT_PREFIX}}}"
 
 # Check for sudo access
 have_sudo_access() {
@@ -83,6 +85,17 @@ execute_sudo() {
   fi
 }
 
+# A prefix we can create ourselves (such as ~/.cupertino) doesn't need sudo
+prefix_parent="${CUPERTINO_PREFIX}"
+while [[ ! -d "${prefix_parent}" ]]; do
+  prefix_parent="$(dirname "${prefix_parent}")"
+done
+if [[ -w "${prefix_parent}" ]]; then
+  use_sudo=false
+else
+  use_sudo=true
+fi
+
 # Check if we need to create directories
 need_setup=false
 if [[ ! -d "${CUPERTINO_PREFIX}" ]]; then
@@ -106,19 +119,24 @@ if [[ "${need_setup}" == "true" ]]; then
     fi
   fi
 
-  # Check for sudo access
-  if ! have_sudo_access; then
-    abort "Need sudo access to create ${CUPERTINO_PREFIX}"
-  fi
+  if [[ "${use_sudo}" == "true" ]]; then
+    # Check for sudo access
+    if ! have_sudo_access; then
+      abort "Need sudo access to create ${CUPERTINO_PREFIX} (set CUPERTINO_HOME=~/.cupertino to install without sudo)"
+    fi
 
-  # Create directory structure
-  ohai "Creating Cupertino directories..."
-  execute_sudo mkdir -p "${CUPERTINO_PREFIX}"/{bin,packages,cache}
+    # Create directory structure
+    ohai "Creating Cupertino directories..."
+    execute_sudo mkdir -p "${CUPERTINO_PREFIX}"/{bin,packages,cache}
 
-  # Set proper ownership (user:admin on macOS)
-  ohai "Setting up permissions..."
-  execute_sudo chown -R "${USER}:admin" "${CUPERTINO_PREFIX}"
-  execute_sudo chmod -R 755 "${CUPERTINO_PREFIX}"
+    # Set proper ownership (user:admin on macOS)
+    ohai "Setting up permissions..."
+    execute_sudo chown -R "${USER}:admin" "${CUPERTINO_PREFIX}"
+    execute_sudo chmod -R 755 "${CUPERTINO_PREFIX}"
+  else
+    ohai "Creating Cupertino directories..."
+    mkdir -p "${CUPERTINO_PREFIX}"/{bin,packages,cache}
+  fi
 fi
 
 # Install cupertino binary
@@ -143,7 +161,7 @@ chmod +x "${CUPERTINO_PREFIX}/bin/cupertino"
 ohai "Setting up PATH..."
 
 # Try to add to /etc/paths.d for system-wide PATH (like Homebrew does)
-if [[ -d "/etc/paths.d" && -x "$(command -v tee)" ]]; then
+if [[ "${use_sudo}" == "true" && "${CUPERTINO_PREFIX}" == "${DEFAULT_PREFIX}" && -d "/etc/paths.d" && -x "$(command -v tee)" ]]; then
   if ! grep -q "${CUPERTINO_PREFIX}/bin" /etc/paths.d/cupertino 2>/dev/null; then
     echo "${CUPERTINO_PREFIX}/bin" | execute_sudo tee /etc/paths.d/cupertino >/dev/null
     execute_sudo chown root:wheel /etc/paths.d/cupertino
@@ -161,6 +179,9 @@ else
   if [[ -f "${shell_rcfile}" ]] && ! grep -q "cupertino" "${shell_rcfile}"; then
     echo "" >> "${shell_rcfile}"
     echo "# Cupertino package manager" >> "${shell_rcfile}"
+    if [[ "${CUPERTINO_PREFIX}" != "${DEFAULT_PREFIX}" ]]; then
+      echo "export CUPERTINO_HOME=\"${CUPERTINO_PREFIX}\"" >> "${shell_rcfile}"
+    fi
     echo "export PATH=\"${CUPERTINO_PREFIX}/bin:\$PATH\"" >> "${shell_rcfile}"
     ohai "Added Cupertino to PATH in ${shell_rcfile}"
   fi
@@ -176,6 +197,9 @@ echo ""
 if [[ ":${PATH}:" != *":${CUPERTINO_PREFIX}/bin:"* ]]; then
   ohai "Next steps:"
   echo "- Add Cupertino to your PATH by running:"
+  if [[ "${CUPERTINO_PREFIX}" != "${DEFAULT_PREFIX}" ]]; then
+    echo "    export CUPERTINO_HOME=\"${CUPERTINO_PREFIX}\""
+  fi
   echo "    export PATH=\"${CUPERTINO_PREFIX}/bin:\$PATH\""
   echo "- Or restart your terminal to pick up the PATH changes"
   echo ""
@@ -3,7 +3,28 @@ import { listPackages, addPackage } from "@/lib/db";
 import { requireAdmin } from "@/lib/auth";
 import { uploadPackageBlob } from "@/lib/blob";
 import type { PackageUpload } from "@/lib/types";
-import { createHash } from "crypto";
+import { createHash, createPublicKey, verify } from "crypto";
+
+// DER prefix that turns a raw 32-byte ed25519 public key into SPKI
+const ED25519_SPKI_PREFIX = Buffer.from("302a300506032b6570032100", "hex");
+
+// verifySignature checks a signature made by `cupertino publish --sign`. It
+// covers the package name, version and tarball checksum.
+function verifySignature(upload: PackageUpload, checksum: string): boolean {
+  try {
+    const rawKey = Buffer.from(upload.signing_key ?? "", "base64");
+    if (rawKey.length !== 32) return false;
+    const key = createPublicKey({
+      key: Buffer.concat([ED25519_SPKI_PREFIX, rawKey]),
+      format: "der",
+      type: "spki",
+    });
+    const message = Buffer.from(`cupertino-signature-v1\n${upload.name}\n${upload.version}\n${checksum}`);
+    return verify(null, message, key, Buffer.from(upload.signature ?? "", "base64"));
+  } catch {
+    return false;
+  }
+}
 
 export async function GET(request: NextRequest) {
   const { searchParams } = request.nextUrl;
@@ -76,6 +97,13 @@ export async function POST(request: NextRequest) {
   const checksum = createHash("sha256").update(buffer).digest("hex");
   const size = buffer.length;
 
+  if ((upload.signature || upload.signing_key) && !verifySignature(upload, checksum)) {
+    return NextResponse.json(
+      { error: "Bad Request", message: "Signature does not match the uploaded package" },
+      { status: 400 }
+    );
+  }
+
   let blobUrl: string;
   try {
     blobUrl = await uploadPackageBlob(upload.name, upload.version, new Blob([buffer]));
@@ -39,6 +39,8 @@ export async function ensureTables() {
       UNIQUE(name, version)
     )
   `;
+  await sql`ALTER TABLE packages ADD COLUMN IF NOT EXISTS signature TEXT`;
+  await sql`ALTER TABLE packages ADD COLUMN IF NOT EXISTS signing_key TEXT`;
   await sql`CREATE INDEX IF NOT EXISTS idx_packages_name ON packages(name)`;
   await sql`CREATE INDEX IF NOT EXISTS idx_packages_upload_date ON packages(upload_date)`;
   await sql`
@@ -64,12 +66,14 @@ export async function addPackage(pkg: {
   const sql = await withTables();
   const rows = await sql`
     INSERT INTO packages (name, version, description, homepage, license,
-                          dependencies, files, checksum, size, upload_date, download_url)
+                          dependencies, files, checksum, size, upload_date, download_url,
+                          signature, signing_key)
     VALUES (${pkg.upload.name}, ${pkg.upload.version}, ${pkg.upload.description},
             ${pkg.upload.homepage ?? null}, ${pkg.upload.license ?? null},
             ${JSON.stringify(pkg.upload.dependencies ?? {})}::jsonb,
             ${JSON.stringify(pkg.upload.files)}::jsonb,
-            ${pkg.checksum}, ${pkg.size}, NOW(), ${pkg.downloadUrl})
+            ${pkg.checksum}, ${pkg.size}, NOW(), ${pkg.downloadUrl},
+            ${pkg.upload.signature ?? null}, ${pkg.upload.signing_key ?? null})
     RETURNING *
   `;
   return rowToPackage(rows[0]);
@@ -79,7 +83,8 @@ export async function getPackage(name: string, version: string): Promise<Package
   const sql = await withTables();
   const rows = await sql`
     SELECT name, version, description, homepage, license, dependencies,
-           files, checksum, size, upload_date, download_url, downloads
+           files, checksum, size, upload_date, download_url, downloads,
+           signature, signing_key
     FROM packages
     WHERE name = ${name} AND version = ${version}
   `;
@@ -281,5 +286,7 @@ function rowToPackage(row: Record<string, unknown>): Package {
     upload_date: (row.upload_date as Date)?.toISOString?.() ?? String(row.upload_date),
     download_url: row.download_url as string,
     downloads: Number(row.downloads ?? 0),
+    signature: (row.signature as string) || undefined,
+    signing_key: (row.signing_key as string) || undefined,
   };
 }
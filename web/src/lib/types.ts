@@ -12,6 +12,8 @@ export interface Package {
   upload_date: string;
   download_url: string;
   downloads?: number;
+  signature?: string;
+  signing_key?: string;
 }
 
 export interface PackageInfo {
@@ -32,6 +34,8 @@ export interface PackageUpload {
   license?: string;
   dependencies?: Record<string, string>;
   files: Record<string, string>;
+  signature?: string;
+  signing_key?: string;
 }
 
 export interface RegistryStats {